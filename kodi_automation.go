@@ -1,22 +1,34 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/HawkMachine/kodi_automation/moveserver"
 	"github.com/HawkMachine/kodi_automation/platform"
+	"github.com/HawkMachine/kodi_automation/platform/notifications"
 	"github.com/HawkMachine/kodi_automation/server"
+	"github.com/HawkMachine/kodi_automation/server/auth"
+	"github.com/HawkMachine/kodi_automation/views/apiview"
 	"github.com/HawkMachine/kodi_automation/views/cronview"
+	"github.com/HawkMachine/kodi_automation/views/eventsview"
 	"github.com/HawkMachine/kodi_automation/views/kodiview"
 	"github.com/HawkMachine/kodi_automation/views/moveserverview"
+	"github.com/HawkMachine/kodi_automation/views/notificationsview"
+	"github.com/HawkMachine/kodi_automation/views/settingsview"
 	"github.com/HawkMachine/kodi_automation/views/transmissionview"
 	"github.com/HawkMachine/kodi_automation/views/uploadtorrentview"
 	"github.com/HawkMachine/kodi_automation/views/wrapview"
@@ -44,8 +56,19 @@ var (
 	transmissionUsername = flag.String("transmission_username", "", "Username of transmission.")
 	transmissionPassword = flag.String("transmission_password", "", "Password of transmission.")
 
-	basicAuthUsername = flag.String("auth_username", "admin", "Basic auth username")
-	basicAuthPassword = flag.String("auth_password", "4dm1n", "Basic auth password")
+	basicAuthUsername = flag.String("auth_username", "", "Username of a user created at start")
+	basicAuthPassword = flag.String("auth_password", "", "Password of a user created at start")
+	usersFile         = flag.String("users_file", "", "File with users and their password hashes")
+	tokensFile        = flag.String("tokens_file", "", "File with API tokens")
+	setPassword       = flag.String("set_password", "", "Set password of the user in the users file, read from stdin, and exit")
+	userRole          = flag.String("role", "", "Role of the user set with -set_password: viewer, operator or admin")
+	insecureNoAuth    = flag.Bool("insecure_no_auth", false, "Start without users, anyone who can reach the server can use it")
+
+	tlsCertFile    = flag.String("tls_cert_file", "", "TLS certificate file, serves HTTPS if set")
+	tlsKeyFile     = flag.String("tls_key_file", "", "TLS key file")
+	tlsSelfSigned  = flag.Bool("tls_self_signed", false, "Generate a self-signed certificate if the TLS files do not exist")
+	trustedProxies = flag.String("trusted_proxies", "", "comma-separated list of reverse proxy addresses or CIDR ranges")
+	basePath       = flag.String("base_path", "", "Path prefix the server is reachable under, e.g. /kodi-helper/")
 
 	configFile = flag.String("config_file", "", "Config file")
 )
@@ -69,10 +92,98 @@ type config struct {
 	TransmissionUsername string `json:"transmission_username,omitempty"`
 	TransmissionPassword string `json:"transmission_password,omitempty"`
 
+	// A user created at start if it is not in the users file. Prefer adding
+	// users with -set_password, the users file only keeps password hashes.
 	BasicAuthUsername string `json:"basic_auth_username,omitempty"`
 	BasicAuthPassword string `json:"basic_auth_password,omitempty"`
 
+	// File with users. The server does not start without users unless
+	// authentication is disabled with insecure_no_auth.
+	UsersFile      string `json:"users_file,omitempty"`
+	InsecureNoAuth bool   `json:"insecure_no_auth,omitempty"`
+
+	// File with API tokens, tokens are lost on restart if empty.
+	TokensFile string `json:"tokens_file,omitempty"`
+
+	// Hours until users have to log in again, defaults to a week.
+	SessionTTLHours int `json:"session_ttl_hours,omitempty"`
+
+	// Serve HTTPS with the certificate and key. With tls_self_signed a
+	// certificate is generated into the files if they do not exist.
+	TLSCertFile   string `json:"tls_cert_file,omitempty"`
+	TLSKeyFile    string `json:"tls_key_file,omitempty"`
+	TLSSelfSigned bool   `json:"tls_self_signed,omitempty"`
+
+	// Addresses or CIDR ranges of reverse proxies whose X-Forwarded-For and
+	// X-Forwarded-Proto headers are trusted.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	// Path prefix the server is reachable under, e.g. "/kodi-helper/".
+	BasePath string `json:"base_path,omitempty"`
+
 	WaitForIP int `json:"wait_for_ip,omitempty"`
+
+	// Directory cron job histories are persisted to, not persisted if empty.
+	CronHistoryDir string `json:"cron_history_dir,omitempty"`
+
+	// Sinks imports, failed moves and full disks are sent to.
+	NotificationSinks []notifications.SinkConfig `json:"notification_sinks,omitempty"`
+}
+
+// redacted returns a copy of the config without passwords and tokens, for
+// logging.
+func (c config) redacted() config {
+	const hidden = "<redacted>"
+	if c.KodiPassword != "" {
+		c.KodiPassword = hidden
+	}
+	if c.TransmissionPassword != "" {
+		c.TransmissionPassword = hidden
+	}
+	if c.BasicAuthPassword != "" {
+		c.BasicAuthPassword = hidden
+	}
+	var sinks []notifications.SinkConfig
+	for _, sc := range c.NotificationSinks {
+		if sc.Token != "" {
+			sc.Token = hidden
+		}
+		if sc.SMTPPassword != "" {
+			sc.SMTPPassword = hidden
+		}
+		sinks = append(sinks, sc)
+	}
+	c.NotificationSinks = sinks
+	return c
+}
+
+// setUserPassword reads a password from stdin and sets it for the user in the
+// users file. The role is only changed if not empty.
+func setUserPassword(usersFile, username, role string) error {
+	if usersFile == "" {
+		return fmt.Errorf("Missing users file")
+	}
+	users, err := auth.LoadUsers(usersFile)
+	if err != nil {
+		return err
+	}
+	if role != "" {
+		if _, err := auth.ParseRole(role); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s: ", username)
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if err := users.SetPassword(username, strings.TrimRight(password, "\r\n")); err != nil {
+		return err
+	}
+	if role != "" {
+		return users.SetRole(username, auth.Role(role))
+	}
+	return nil
 }
 
 func loadConfigFromFile(path string) (*config, error) {
@@ -121,6 +232,16 @@ func parseCustomLinksFlag(str string) map[string]string {
 	return res
 }
 
+func parseListFlag(str string) []string {
+	var res []string
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
 func replaceLocalHost(m map[string]string, ip string) map[string]string {
 	n := map[string]string{}
 	for k, v := range m {
@@ -138,7 +259,6 @@ func main() {
 		var err error
 		log.Printf("Loading from config %s", *configFile)
 		cfg, err = loadConfigFromFile(*configFile)
-		log.Println(cfg)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -172,12 +292,28 @@ func main() {
 
 			BasicAuthUsername: *basicAuthUsername,
 			BasicAuthPassword: *basicAuthPassword,
+			UsersFile:         *usersFile,
+			TokensFile:        *tokensFile,
 
 			WaitForIP: *waitForIP,
+
+			TLSCertFile:    *tlsCertFile,
+			TLSKeyFile:     *tlsKeyFile,
+			TLSSelfSigned:  *tlsSelfSigned,
+			TrustedProxies: parseListFlag(*trustedProxies),
+			BasePath:       *basePath,
 		}
 	}
 
-	log.Printf("CONFIG           = %#v", cfg)
+	if *setPassword != "" {
+		if err := setUserPassword(cfg.UsersFile, *setPassword, *userRole); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Password of %s set in %s", *setPassword, cfg.UsersFile)
+		return
+	}
+
+	log.Printf("CONFIG           = %#v", cfg.redacted())
 
 	var err error
 	if cfg.MoveServer.SourceDir == "" {
@@ -229,7 +365,7 @@ func main() {
 	cfg.Links = replaceLocalHost(cfg.Links, ip)
 	cfg.IframeLinks = replaceLocalHost(cfg.IframeLinks, ip)
 
-	log.Printf("CONFIG           = %#v", cfg)
+	log.Printf("CONFIG           = %#v", cfg.redacted())
 	log.Printf("PORT             = %d", cfg.Port)
 	log.Printf("TEMPLATES PATH   = %s", cfg.TemplatesPath)
 	log.Printf("RESOURCES PATH   = %s", cfg.ResourcesPath)
@@ -254,16 +390,60 @@ func main() {
 		cfg.TransmissionPassword)
 
 	p := platform.New(c)
+	if cfg.CronHistoryDir != "" {
+		if err := p.Cron.SetHistoryDir(cfg.CronHistoryDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := p.StartNotifier(cfg.NotificationSinks); err != nil {
+		log.Fatal(err)
+	}
+
+	// Users.
+	users, err := auth.LoadUsers(cfg.UsersFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.BasicAuthUsername != "" && cfg.BasicAuthPassword != "" && users.Get(cfg.BasicAuthUsername) == nil {
+		if err := users.SetPassword(cfg.BasicAuthUsername, cfg.BasicAuthPassword); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if users.Empty() {
+		if !cfg.InsecureNoAuth && !*insecureNoAuth {
+			log.Fatal("No users configured, add one with -set_password or start with -insecure_no_auth to disable authentication")
+		}
+		log.Printf("No users configured, authentication is disabled")
+	}
+	tokens, err := auth.LoadTokens(cfg.TokensFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.SessionTTLHours <= 0 {
+		cfg.SessionTTLHours = 7 * 24
+	}
 
 	// Server.
 	s := server.NewMyHTTPServer(
 		cfg.Port,
-		cfg.BasicAuthUsername,
-		cfg.BasicAuthPassword,
+		auth.New(users, tokens, time.Duration(cfg.SessionTTLHours)*time.Hour),
 		cfg.TemplatesPath,
 		cfg.ResourcesPath,
 		cfg.Links,
 		cfg.IframeLinks)
+	if err := s.SetBasePath(cfg.BasePath); err != nil {
+		log.Fatal(err)
+	}
+	if err := s.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		hostname, _ := os.Hostname()
+		hosts := []string{hostname, "localhost", "127.0.0.1", ip}
+		if err := s.SetTLS(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSSelfSigned, hosts); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Initialize move server view.
 	moveServer, err := moveserver.New(p, cfg.MoveServer)
@@ -282,6 +462,18 @@ func main() {
 	// Cron View
 	views = append(views, cronview.New(s, p))
 
+	// Notifications view.
+	views = append(views, notificationsview.New(p))
+
+	// Settings view.
+	views = append(views, settingsview.New(tokens))
+
+	// JSON API.
+	views = append(views, apiview.New(p, moveServer))
+
+	// Live events.
+	views = append(views, eventsview.New(p))
+
 	// Wrap view.
 	views = append(views, wrapview.New(cfg.IframeLinks))
 
@@ -313,5 +505,18 @@ func main() {
 		}
 	}
 
+	// Let running cron jobs finish on SIGINT/SIGTERM.
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		log.Printf("Received %v, shutting down\n", <-sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := p.Cron.Shutdown(ctx); err != nil {
+			log.Printf("Cron shutdown: %v\n", err)
+		}
+		os.Exit(0)
+	}()
+
 	s.Run()
 }
@@ -1,12 +1,17 @@
 package moveserver
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/HawkMachine/kodi_automation/platform/cron"
+	"github.com/HawkMachine/kodi_automation/platform/events"
 
 	tr "github.com/HawkMachine/transmission_go_api"
 )
@@ -23,17 +28,95 @@ func filterPathInfo(a map[string]*PathInfo, f func(*PathInfo) bool) []*PathInfo
 
 type TorrentStatus struct {
 	Name        string
+	Priority    int
 	MoveStatus  string
 	StartStatus string
 	Status      string
+
+	// Why a torrent allowed to start was not started in this run.
+	NotStartedReason string
+
+	// Why a torrent allowed to move was not moved in this run.
+	NotMovedReason string
+
+	// Why a downloading torrent is considered stalled.
+	Health string
+}
+
+// AssistantConfig configures the Assistant limits.
+type AssistantConfig struct {
+	MaxConcurrentDownloading int `json:"max_concurrent_downloading"`
+	MaxConcurrentMoving      int `json:"max_concurrent_moving"`
+
+	// Bytes that have to stay free on the target disk on top of the size of
+	// the torrent before it is started.
+	FreeSpaceHeadroom int64 `json:"free_space_headroom"`
+
+	// Weekly schedule. The first window containing the current time overrides
+	// the limits above.
+	Schedule []ScheduleWindow `json:"schedule"`
+
+	// Do not start moves while a Kodi player is active.
+	PauseMovesWhilePlaying bool `json:"pause_moves_while_playing"`
+
+	// Only record decisions, do not remove, move or start anything.
+	DryRun bool `json:"dry_run"`
+
+	// Detection of stalled torrents.
+	Stall StallConfig `json:"stall"`
+
+	// Seconds after which a run is canceled, defaults to 10 minutes.
+	Timeout int `json:"timeout"`
+
+	// Attempts of a failed run, defaults to 3, and seconds before the first
+	// retry, defaults to 10. The delay doubles with each retry.
+	RetryAttempts int `json:"retry_attempts"`
+	RetryBackoff  int `json:"retry_backoff"`
+
+	// Consecutive failed runs after which a notification is sent.
+	AlertAfter int `json:"alert_after"`
+}
+
+// Decision is a single entry of the Assistant decision log.
+type Decision struct {
+	T      time.Time
+	DryRun bool
+	Msg    string
 }
 
+const maxDecisionLog = 1000
+
 type Assistant struct {
 	msv                      *MoveServer
 	sleep                    time.Duration
+	timeout                  time.Duration
+	retry                    cron.RetryPolicy
+	alertAfter               int
 	dryRun                   bool
 	maxConcurrentDownloading int
 	maxConcurrentMoving      int
+	freeSpaceHeadroom        int64
+	schedule                 []*scheduleWindow
+	pauseMovesWhilePlaying   bool
+
+	// Alternative speed state last set in Transmission, nil if never set.
+	altSpeed *bool
+
+	// Schedule window active during the last run and why moves were not
+	// allowed in it.
+	ActiveWindow       string
+	MovesBlockedReason string
+
+	// Last run and log of decisions of past runs, oldest first.
+	lastRun     *AssistantRun
+	decisionLog []*Decision
+
+	// Per item timelines of decisions and actions.
+	timelines map[string]*itemTimeline
+
+	// Stall detection.
+	stall  StallConfig
+	health map[string]*torrentHealth
 
 	enabled       bool
 	runStarted    bool
@@ -44,14 +127,155 @@ type Assistant struct {
 	lock sync.Mutex
 }
 
-func newAssistant(msv *MoveServer) *Assistant {
+func newAssistant(msv *MoveServer, c AssistantConfig) (*Assistant, error) {
+	schedule, err := parseSchedule(c.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	stall, err := parseStallConfig(c.Stall)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxConcurrentDownloading <= 0 {
+		c.MaxConcurrentDownloading = 5
+	}
+	if c.MaxConcurrentMoving <= 0 {
+		c.MaxConcurrentMoving = 1
+	}
+	if c.FreeSpaceHeadroom <= 0 {
+		c.FreeSpaceHeadroom = 1000000000
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * 60
+	}
+	if c.RetryAttempts <= 0 {
+		c.RetryAttempts = 3
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = 10
+	}
 	return &Assistant{
-		msv:   msv,
-		sleep: time.Minute,
-		maxConcurrentDownloading: 5,
-		maxConcurrentMoving:      1,
+		msv:     msv,
+		sleep:   time.Minute,
+		timeout: time.Duration(c.Timeout) * time.Second,
+		retry: cron.RetryPolicy{
+			MaxAttempts: c.RetryAttempts,
+			Backoff:     time.Duration(c.RetryBackoff) * time.Second,
+			MaxBackoff:  time.Minute,
+		},
+		alertAfter:               c.AlertAfter,
+		maxConcurrentDownloading: c.MaxConcurrentDownloading,
+		maxConcurrentMoving:      c.MaxConcurrentMoving,
+		freeSpaceHeadroom:        c.FreeSpaceHeadroom,
+		schedule:                 schedule,
+		pauseMovesWhilePlaying:   c.PauseMovesWhilePlaying,
+		dryRun:                   c.DryRun,
 		TorrentStatus:            map[string]*TorrentStatus{},
+		timelines:                map[string]*itemTimeline{},
+		stall:                    stall,
+		health:                   map[string]*torrentHealth{},
+	}, nil
+}
+
+// Config returns the limits the Assistant runs with.
+func (a *Assistant) Config() AssistantConfig {
+	return AssistantConfig{
+		MaxConcurrentDownloading: a.maxConcurrentDownloading,
+		MaxConcurrentMoving:      a.maxConcurrentMoving,
+		FreeSpaceHeadroom:        a.freeSpaceHeadroom,
+		PauseMovesWhilePlaying:   a.pauseMovesWhilePlaying,
+		DryRun:                   a.IsDryRun(),
+		Stall:                    a.stall,
+		Timeout:                  int(a.timeout / time.Second),
+		RetryAttempts:            a.retry.MaxAttempts,
+		RetryBackoff:             int(a.retry.Backoff / time.Second),
+		AlertAfter:               a.alertAfter,
+	}
+}
+
+// Schedule returns the configured schedule windows.
+func (a *Assistant) Schedule() []ScheduleWindow {
+	var r []ScheduleWindow
+	for _, w := range a.schedule {
+		r = append(r, w.ScheduleWindow)
+	}
+	return r
+}
+
+// kodiPlaying returns true if Kodi has an active player.
+func (a *Assistant) kodiPlaying(ctx context.Context) (bool, error) {
+	playing := false
+	err := callWithContext(ctx, func() error {
+		resp, err := a.msv.k.Player.GetActivePlayers()
+		if err != nil {
+			return err
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("Kodi error: %v", resp.Error.Message)
+		}
+		playing = len(resp.Result) > 0
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return playing, nil
+}
+
+// setAltSpeed turns Transmission alternative speed limits on or off. It only
+// talks to Transmission when the wanted state changes, so manual changes made
+// inside a window are kept until the next window boundary.
+func (a *Assistant) setAltSpeed(ctx context.Context, on bool) {
+	if a.altSpeed != nil && *a.altSpeed == on {
+		return
+	}
+	err := callWithContext(ctx, func() error {
+		return a.msv.t.SetAltSpeedEnabled(on)
+	})
+	if err != nil {
+		a.Log("schedule", fmt.Sprintf("Setting alt speed to %v failed: %v", on, err))
+		return
 	}
+	a.altSpeed = &on
+	a.Log("schedule", fmt.Sprintf("Alt speed set to %v", on))
+}
+
+// scheduleLimits are the limits of the schedule window active at some time.
+type scheduleLimits struct {
+	window         string
+	maxDownloading int
+	movesBlocked   string // Why moves are not allowed, empty if they are.
+	altSpeed       *bool  // Nil if the schedule does not manage alt speed.
+}
+
+// scheduleLimitsAt returns limits of the schedule window active at t.
+func (a *Assistant) scheduleLimitsAt(ctx context.Context, t time.Time) scheduleLimits {
+	l := scheduleLimits{maxDownloading: a.maxConcurrentDownloading}
+
+	w := activeWindow(a.schedule, t)
+	if w != nil {
+		l.window = fmt.Sprintf("%s (%s-%s)", w.Name, w.Start, w.End)
+		if w.MaxConcurrentDownloading != nil {
+			l.maxDownloading = *w.MaxConcurrentDownloading
+		}
+		if w.DisallowMoves {
+			l.movesBlocked = fmt.Sprintf("Moves not allowed in schedule window %s", l.window)
+		}
+	}
+	if len(a.schedule) > 0 {
+		altSpeed := w != nil && w.AltSpeed
+		l.altSpeed = &altSpeed
+	}
+
+	if l.movesBlocked == "" && a.pauseMovesWhilePlaying {
+		playing, err := a.kodiPlaying(ctx)
+		if err != nil {
+			a.Log("schedule", fmt.Sprintf("Checking Kodi players failed: %v", err))
+		} else if playing {
+			l.movesBlocked = "Kodi is playing"
+		}
+	}
+	return l
 }
 
 func (a *Assistant) Log(tp, msg string) {
@@ -68,7 +292,11 @@ func (a *Assistant) Enable() {
 
 	if a.j == nil {
 		// TODO: do not ignore errors
-		a.j, _ = a.msv.p.Cron.Register("assistant", a.assist, a.sleep)
+		a.j, _ = a.msv.p.Cron.RegisterWithOptions("assistant", a.assist, cron.Every(a.sleep), cron.Options{
+			Timeout:    a.timeout,
+			Retry:      a.retry,
+			AlertAfter: a.alertAfter,
+		})
 	}
 	a.j.Enable()
 }
@@ -92,6 +320,41 @@ func (a *Assistant) IsEnabled() bool {
 	return a.j.IsEnabled()
 }
 
+func (a *Assistant) SetDryRun(dryRun bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.dryRun = dryRun
+	a.Log("dryrun", fmt.Sprintf("Dry run set to %v", dryRun))
+}
+
+func (a *Assistant) IsDryRun() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.dryRun
+}
+
+// LastRun returns the last recorded run, nil if there was none.
+func (a *Assistant) LastRun() *AssistantRun {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.lastRun
+}
+
+// DecisionLog returns the decision log, newest first.
+func (a *Assistant) DecisionLog() []*Decision {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	r := make([]*Decision, 0, len(a.decisionLog))
+	for i := len(a.decisionLog) - 1; i >= 0; i-- {
+		r = append(r, a.decisionLog[i])
+	}
+	return r
+}
+
 func (a *Assistant) shouldMove(pi *PathInfo) (bool, string) {
 	if !pi.AllowMove {
 		return false, "AllowMove = false"
@@ -102,6 +365,9 @@ func (a *Assistant) shouldMove(pi *PathInfo) (bool, string) {
 	if pi.MoveInfo.Moving {
 		return false, "Currently moving"
 	}
+	if pi.Imported {
+		return false, "Already imported, kept for seeding"
+	}
 	if pi.Path == "" {
 		return false, "Path is empty, probably just in transmission"
 	}
@@ -117,6 +383,11 @@ func (a *Assistant) shouldMove(pi *PathInfo) (bool, string) {
 	if pi.Torrent.PercentDone != 1.0 {
 		return false, "Torrent.PercentDone != 1.0"
 	}
+	// Copies, hardlinks and relocations by Transmission can be made while the
+	// torrent is seeding.
+	if a.msv.importModeFor(pi.MoveTo).KeepsTorrent() {
+		return true, "Allowed to be imported, torrent keeps seeding"
+	}
 	if pi.Torrent.Status != tr.TR_STATUS_PAUSED {
 		return false, fmt.Sprintf("Torrent.Status != TR_STATUS_PAUSED, == %v", pi.Torrent.Status)
 	}
@@ -139,21 +410,110 @@ func (a *Assistant) shouldStart(pi *PathInfo) (bool, string) {
 	return true, "Allowed to start"
 }
 
-func (a *Assistant) assist() error {
+// hasSpace checks that the download and target disks are below their
+// thresholds and the target disk has room for the torrent and the headroom.
+// reserved holds bytes already promised on each disk.
+func (a *Assistant) hasSpace(snap *assistSnapshot, pi *PathInfo, reserved map[string]int64) (bool, string) {
+	if src := diskStatsFor(snap.diskStats, a.msv.sourceDir); src != nil && src.OverThreshold() {
+		return false, fmt.Sprintf("Download disk %s is %d%% full, above the %d%% threshold",
+			src.Path, src.PercentFull, src.Threshold)
+	}
+	ds, err := a.msv.targetDiskStats(snap.diskStats, pi)
+	if err != nil {
+		return false, err.Error()
+	}
+	if ds == nil {
+		return true, fmt.Sprintf("No disk stats for %s, space not checked", pi.MoveTo)
+	}
+	if ds.OverThreshold() {
+		return false, fmt.Sprintf("Target disk %s is %d%% full, above the %d%% threshold",
+			ds.Path, ds.PercentFull, ds.Threshold)
+	}
+	need := pi.Torrent.SizeWhenDone + a.freeSpaceHeadroom
+	avail := ds.Avail - reserved[ds.Path]
+	if need > avail {
+		return false, fmt.Sprintf("Not enough space on %s: need %d bytes (incl. %d headroom), %d available",
+			ds.Path, need, a.freeSpaceHeadroom, avail)
+	}
+	return true, ""
+}
+
+// byPriority sorts path info by priority, highest first, then by name.
+type byPriority []*PathInfo
+
+func (p byPriority) Len() int      { return len(p) }
+func (p byPriority) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byPriority) Less(i, j int) bool {
+	if p[i].Priority != p[j].Priority {
+		return p[i].Priority > p[j].Priority
+	}
+	return p[i].Name < p[j].Name
+}
+
+// AssistantRun holds decisions of a single Assistant run.
+type AssistantRun struct {
+	T             time.Time
+	DryRun        bool
+	TorrentStatus map[string]*TorrentStatus
+	Decisions     []string
+
+	limits   scheduleLimits
+	toMove   []*PathInfo // Snapshot copies.
+	toStart  []*PathInfo // Snapshot copies.
+	remedies []*stallRemedy
+}
+
+func (r *AssistantRun) decide(format string, args ...interface{}) {
+	r.Decisions = append(r.Decisions, fmt.Sprintf(format, args...))
+}
+
+// assistSnapshot is a copy of the MoveServer state the Assistant decides on.
+// Decisions are made without holding msv.lock.
+type assistSnapshot struct {
+	pathInfo  map[string]*PathInfo
+	diskStats []DiskStats
+	health    map[string]*torrentHealth // Set by observe.
+}
+
+func (a *Assistant) snapshot() *assistSnapshot {
 	a.msv.lock.Lock()
 	defer a.msv.lock.Unlock()
 
+	snap := &assistSnapshot{
+		pathInfo:  map[string]*PathInfo{},
+		diskStats: append([]DiskStats(nil), a.msv.diskStats...),
+	}
+	for name, pi := range a.msv.pathInfo {
+		c := *pi
+		snap.pathInfo[name] = &c
+	}
+	return snap
+}
+
+// plan decides what a run at t should do basing on the snapshot. It does not
+// change anything.
+func (a *Assistant) plan(snap *assistSnapshot, t time.Time, limits scheduleLimits) *AssistantRun {
+	run := &AssistantRun{
+		T:             t,
+		DryRun:        a.IsDryRun(),
+		TorrentStatus: map[string]*TorrentStatus{},
+		limits:        limits,
+	}
+	if limits.window != "" {
+		run.decide("Schedule window %s active", limits.window)
+	}
+
 	// Select candidates for moving.
 	// Very, very simple logic. Check if there are any torrents, allowed to move
 	// without move error. These must have torrent info and be paused (I rely
 	// here on a cron job that automatically pause finished torrents.
-	tss := map[string]*TorrentStatus{}
-	pis := a.msv.pathInfo
+	tss := run.TorrentStatus
+	pis := snap.pathInfo
 	toMove := []*PathInfo{}
 	todo := []*PathInfo{}
 
 	for _, pi := range pis {
-		ts := &TorrentStatus{Name: pi.Name}
+		ts := &TorrentStatus{Name: pi.Name, Priority: pi.Priority}
 		tss[pi.Name] = ts
 		shouldMove, moveStatus := a.shouldMove(pi)
 		shouldStart, startStatus := a.shouldStart(pi)
@@ -172,8 +532,6 @@ func (a *Assistant) assist() error {
 			ts.Status = "NOTHING TO DO"
 		}
 	}
-	a.TorrentStatus = tss
-	log.Printf("Assitant: new torrent statuses: %v", tss)
 
 	downloading := filterPathInfo(pis, func(pi *PathInfo) bool {
 		return pi.Torrent != nil && pi.Torrent.Status != tr.TR_STATUS_PAUSED
@@ -182,55 +540,346 @@ func (a *Assistant) assist() error {
 		return pi.MoveInfo.Moving
 	})
 
-	// *** Request to move some torrents.
-	toMoveSelected := []*PathInfo{}
+	// *** Select torrents to move.
+	sort.Sort(byPriority(toMove))
 	for _, pi := range toMove {
-		if len(toMoveSelected)+len(moving) >= a.maxConcurrentMoving {
-			break
+		if limits.movesBlocked != "" {
+			tss[pi.Name].NotMovedReason = limits.movesBlocked
+			continue
+		}
+		if len(run.toMove)+len(moving) >= a.maxConcurrentMoving {
+			tss[pi.Name].NotMovedReason = fmt.Sprintf("Move limit reached, max %d", a.maxConcurrentMoving)
+			continue
+		}
+		if _, err := a.msv.targetDiskStats(snap.diskStats, pi); err != nil {
+			tss[pi.Name].NotMovedReason = err.Error()
+			continue
+		}
+		mode := a.msv.importModeFor(pi.MoveTo)
+		// Torrents imported with a copy, a hardlink or relocated by
+		// Transmission stay there to seed, others are removed first.
+		if !mode.KeepsTorrent() {
+			run.decide("Remove %s from Transmission (Magnet: %s)", pi.Name, pi.Torrent.MagnetLink)
+		}
+		run.toMove = append(run.toMove, pi)
+		tss[pi.Name].Status = "MOVE"
+		run.decide("Move (%s) %s to %s", mode, pi.Name, pi.MoveTo)
+	}
+
+	// *** Handle stalled torrents. Stopped ones free their download slots.
+	freed := a.planRemedies(run, snap)
+	attention := a.NeedsAttention()
+
+	// *** Select torrents to start, highest priority first. Space on the
+	// target disk is reserved for everything that is already downloading.
+	reserved := map[string]int64{}
+	for _, pi := range downloading {
+		if ds, _ := a.msv.targetDiskStats(snap.diskStats, pi); ds != nil {
+			reserved[ds.Path] += pi.Torrent.LeftUntilDone
 		}
-		toMoveSelected = append(toMoveSelected, pi)
 	}
-	// First try removing those torrents from Transmission.
-	if len(toMoveSelected) > 0 {
-		logMsg := "Removing torrents from transmission"
-		var toMoveTorrents []*tr.Torrent
-		for _, pi := range toMoveSelected {
-			logMsg += fmt.Sprintf(", %s (Magnet: %s)", pi.Name, pi.Torrent.MagnetLink)
-			toMoveTorrents = append(toMoveTorrents, pi.Torrent)
+	sort.Sort(byPriority(todo))
+	for _, pi := range todo {
+		ts := tss[pi.Name]
+		if reason, ok := attention[pi.Name]; ok {
+			ts.NotStartedReason = fmt.Sprintf("Needs attention: %s", reason)
+			continue
+		}
+		if n := len(run.toStart) + len(downloading) - freed; n >= limits.maxDownloading {
+			ts.NotStartedReason = fmt.Sprintf("Download limit reached, %d downloading, max %d",
+				n, limits.maxDownloading)
+			continue
+		}
+		ok, reason := a.hasSpace(snap, pi, reserved)
+		ts.NotStartedReason = reason
+		if !ok {
+			continue
+		}
+		if ds, _ := a.msv.targetDiskStats(snap.diskStats, pi); ds != nil {
+			reserved[ds.Path] += pi.Torrent.SizeWhenDone
 		}
-		a.Log("assist", logMsg)
-		err := a.msv.t.RemoveTorrents(toMoveTorrents)
+		run.toStart = append(run.toStart, pi)
+		ts.Status = "START"
+		run.decide("Start %s (priority %d)", pi.Name, pi.Priority)
+	}
+
+	if len(run.Decisions) == 0 {
+		run.decide("Nothing to do")
+	}
+	return run
+}
+
+// conflictLocked returns an error if the current path info changed since the
+// decision was made on its snapshot copy, msv.lock has to be held.
+func (a *Assistant) conflictLocked(snap *PathInfo) (*PathInfo, error) {
+	pi, ok := a.msv.pathInfo[snap.Name]
+	if !ok {
+		return nil, fmt.Errorf("%s disappeared since the decision", snap.Name)
+	}
+	if pi.MoveInfo.Moving {
+		return nil, fmt.Errorf("%s is moving since the decision", snap.Name)
+	}
+	if pi.MoveTo != snap.MoveTo {
+		return nil, fmt.Errorf("%s move path changed since the decision from %s to %s", snap.Name, snap.MoveTo, pi.MoveTo)
+	}
+	if !pi.AllowAssistant || pi.Imported {
+		return nil, fmt.Errorf("%s is not handled by the Assistant any more", snap.Name)
+	}
+	if (pi.Torrent == nil) != (snap.Torrent == nil) ||
+		(pi.Torrent != nil && pi.Torrent.HashString != snap.Torrent.HashString) {
+		return nil, fmt.Errorf("%s torrent changed since the decision", snap.Name)
+	}
+	return pi, nil
+}
+
+// claimMoves marks items selected for moving as moving, so nobody else moves
+// them while the Assistant talks to Transmission. Items that changed since
+// the decision are skipped.
+func (a *Assistant) claimMoves(run *AssistantRun) []*PathInfo {
+	a.msv.lock.Lock()
+	defer a.msv.lock.Unlock()
+
+	var claimed []*PathInfo
+	for _, snap := range run.toMove {
+		pi, err := a.conflictLocked(snap)
 		if err != nil {
-			return fmt.Errorf("Removing torrents from transmission failed: %v", err)
-		}
-		hadMoveErrors := false
-		for _, pi := range toMoveSelected {
-			a.Log("assist", fmt.Sprintf("Moving %s to %s", pi.Name))
-			err := a.msv.moveLocked(pi)
-			if err != nil {
-				hadMoveErrors = true
-				a.Log("assist", fmt.Sprintf("Moving %s to %s failed: %v", pi.Name, err))
-			}
+			a.Log("assist", fmt.Sprintf("Not moving: %v", err))
+			a.recordAction(snap.Name, "Skip move", err)
+			continue
+		}
+		pi.MoveInfo.Moving = true
+		pi.MoveInfo.Target = filepath.Join(pi.MoveTo, filepath.Base(pi.Path))
+		pi.MoveInfo.Status = "Assistant is preparing the move"
+		claimed = append(claimed, snap)
+	}
+	return claimed
+}
+
+// releaseClaimLocked reverts claimMoves for the item, msv.lock has to be held.
+func (a *Assistant) releaseClaimLocked(name string) *PathInfo {
+	pi, ok := a.msv.pathInfo[name]
+	if !ok {
+		return nil
+	}
+	pi.MoveInfo.Moving = false
+	pi.MoveInfo.Target = ""
+	pi.MoveInfo.Status = ""
+	return pi
+}
+
+// applyMoves requests moves of claimed items. Items whose torrent could not be
+// removed from Transmission are only released.
+func (a *Assistant) applyMoves(claimed []*PathInfo, notRemoved map[string]bool) bool {
+	estimates := map[string]*moveEstimate{}
+	for _, snap := range claimed {
+		if !notRemoved[snap.Name] {
+			estimates[snap.Name] = a.msv.estimateMove(snap)
+		}
+	}
+
+	a.msv.lock.Lock()
+	defer a.msv.lock.Unlock()
+
+	hadMoveErrors := false
+	for _, snap := range claimed {
+		pi := a.releaseClaimLocked(snap.Name)
+		if notRemoved[snap.Name] {
+			continue
 		}
-		if hadMoveErrors {
-			return fmt.Errorf("Requesting move had failures")
+		if pi == nil {
+			err := fmt.Errorf("%s disappeared while removing its torrent", snap.Name)
+			a.recordAction(snap.Name, "Request move", err)
+			hadMoveErrors = true
+			continue
+		}
+		mode := a.msv.importModeFor(pi.MoveTo)
+		a.Log("assist", fmt.Sprintf("Moving (%s) %s to %s", mode, pi.Name, pi.MoveTo))
+		err := a.msv.moveLocked(pi, estimates[pi.Name])
+		a.recordAction(pi.Name, fmt.Sprintf("Request move (%s) to %s", mode, pi.MoveTo), err)
+		if err != nil {
+			hadMoveErrors = true
+			a.Log("assist", fmt.Sprintf("Moving %s to %s failed: %v", pi.Name, pi.MoveTo, err))
 		}
 	}
+	return hadMoveErrors
+}
+
+// checkStarts returns torrents of items selected for starting that did not
+// change since the decision.
+func (a *Assistant) checkStarts(run *AssistantRun) []*tr.Torrent {
+	a.msv.lock.Lock()
+	defer a.msv.lock.Unlock()
 
-	// Enable more torrents.
 	var toStart []*tr.Torrent
-	for _, pi := range todo {
-		if len(toStart)+len(downloading) >= a.maxConcurrentDownloading {
-			break
+	for _, snap := range run.toStart {
+		pi, err := a.conflictLocked(snap)
+		if err != nil {
+			a.Log("assist", fmt.Sprintf("Not starting: %v", err))
+			a.recordAction(snap.Name, "Skip start", err)
+			continue
 		}
 		toStart = append(toStart, pi.Torrent)
-		a.Log("Starting torrent", pi.Name)
 	}
-	err := a.msv.t.StartTorrents(toStart)
+	return toStart
+}
+
+// execute carries out the run decisions. Calls to Transmission are made
+// without holding msv.lock, the state is checked for conflicts before the
+// results are applied.
+func (a *Assistant) execute(ctx context.Context, run *AssistantRun) error {
+	claimed := a.claimMoves(run)
+
+	var toRemove []*tr.Torrent
+	for _, pi := range claimed {
+		if !a.msv.importModeFor(pi.MoveTo).KeepsTorrent() {
+			toRemove = append(toRemove, pi.Torrent)
+		}
+	}
+	notRemoved := map[string]bool{}
+	var removeErr error
+	if len(toRemove) > 0 {
+		a.Log("assist", fmt.Sprintf("Removing %d torrents from transmission", len(toRemove)))
+		removeErr = callWithContext(ctx, func() error {
+			return a.msv.t.RemoveTorrents(toRemove)
+		})
+		for _, t := range toRemove {
+			a.recordAction(t.Name, "Remove from Transmission", removeErr)
+			if removeErr != nil {
+				notRemoved[t.Name] = true
+			} else {
+				a.publish(events.TorrentState, TorrentStateEvent{Name: t.Name, State: "removed by Assistant", PercentDone: t.PercentDone})
+			}
+		}
+	}
+	if a.applyMoves(claimed, notRemoved) {
+		return fmt.Errorf("Requesting move had failures")
+	}
+	if removeErr != nil {
+		return fmt.Errorf("Removing torrents from transmission failed: %v", removeErr)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Remedies go before starts, they may free download slots.
+	var remedyErr error
+	for _, r := range run.remedies {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := a.applyRemedy(ctx, r); err != nil {
+			a.Log("assist", fmt.Sprintf("Stall remedy %q for %s failed: %v", r.remedy, r.pi.Name, err))
+			remedyErr = err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	toStart := a.checkStarts(run)
+	if len(toStart) == 0 {
+		if remedyErr != nil {
+			return fmt.Errorf("Stall remedies had failures: %v", remedyErr)
+		}
+		return nil
+	}
+	for _, t := range toStart {
+		a.Log("Starting torrent", t.Name)
+	}
+	err := callWithContext(ctx, func() error {
+		return a.msv.t.StartTorrents(toStart)
+	})
+	for _, t := range toStart {
+		a.recordAction(t.Name, "Start", err)
+		if err == nil {
+			a.publish(events.TorrentState, TorrentStateEvent{Name: t.Name, State: "started by Assistant", PercentDone: t.PercentDone})
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("Failed to start torrents: %v", err)
-	} else {
 	}
-
+	if remedyErr != nil {
+		return fmt.Errorf("Stall remedies had failures: %v", remedyErr)
+	}
 	return nil
 }
+
+func (a *Assistant) publish(t events.Type, data interface{}) {
+	a.msv.p.Events.Publish("assistant", t, data)
+}
+
+// recordRun keeps the run statuses and decisions for the Assistant page.
+func (a *Assistant) recordRun(run *AssistantRun) {
+	a.publish(events.AssistantRun, AssistantRunEvent{T: run.T, DryRun: run.DryRun, Decisions: run.Decisions})
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.TorrentStatus = run.TorrentStatus
+	a.ActiveWindow = run.limits.window
+	a.MovesBlockedReason = run.limits.movesBlocked
+	a.lastRun = run
+	for _, d := range run.Decisions {
+		a.decisionLog = append(a.decisionLog, &Decision{T: run.T, DryRun: run.DryRun, Msg: d})
+	}
+	if len(a.decisionLog) > maxDecisionLog {
+		a.decisionLog = a.decisionLog[len(a.decisionLog)-maxDecisionLog:]
+	}
+	for name, ts := range run.TorrentStatus {
+		a.addItemEventLocked(name, &ItemEvent{
+			First:       run.T,
+			Last:        run.T,
+			Count:       1,
+			DryRun:      run.DryRun,
+			Status:      ts.Status,
+			MoveStatus:  ts.MoveStatus,
+			StartStatus: ts.StartStatus,
+			Reason:      joinReasons(ts.NotStartedReason, ts.NotMovedReason),
+		})
+	}
+}
+
+// joinReasons joins the non-empty reasons with "; ".
+func joinReasons(reasons ...string) string {
+	var r []string
+	for _, reason := range reasons {
+		if reason != "" {
+			r = append(r, reason)
+		}
+	}
+	return strings.Join(r, "; ")
+}
+
+// Simulate returns what a run started now would do without doing it.
+func (a *Assistant) Simulate(ctx context.Context) *AssistantRun {
+	limits := a.scheduleLimitsAt(ctx, time.Now())
+	snap := a.snapshot()
+	a.observe(snap, time.Now(), false)
+	return a.plan(snap, time.Now(), limits)
+}
+
+// assist makes a single Assistant run: it takes a snapshot of the MoveServer
+// state, decides on the snapshot and acts on the decisions.
+func (a *Assistant) assist(ctx context.Context) error {
+	limits := a.scheduleLimitsAt(ctx, time.Now())
+	dryRun := a.IsDryRun()
+	if limits.altSpeed != nil && !dryRun {
+		a.setAltSpeed(ctx, *limits.altSpeed)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	snap := a.snapshot()
+	a.observe(snap, time.Now(), true)
+	run := a.plan(snap, time.Now(), limits)
+	a.recordRun(run)
+	log.Printf("Assitant: new torrent statuses: %v", run.TorrentStatus)
+	if dryRun {
+		for _, d := range run.Decisions {
+			a.Log("dryrun", d)
+		}
+		return nil
+	}
+	return a.execute(ctx, run)
+}
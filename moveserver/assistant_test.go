@@ -0,0 +1,188 @@
+package moveserver
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HawkMachine/kodi_automation/platform"
+	"github.com/HawkMachine/kodi_automation/platform/cron"
+	tr "github.com/HawkMachine/transmission_go_api"
+)
+
+// hangingTransmission hangs in every call until released.
+type hangingTransmission struct {
+	release chan struct{}
+	calls   int32
+}
+
+func (t *hangingTransmission) hang() error {
+	atomic.AddInt32(&t.calls, 1)
+	<-t.release
+	return nil
+}
+
+func (t *hangingTransmission) ListAll() ([]*tr.Torrent, error)       { return nil, t.hang() }
+func (t *hangingTransmission) RemoveTorrents(ts []*tr.Torrent) error { return t.hang() }
+func (t *hangingTransmission) StartTorrents(ts []*tr.Torrent) error  { return t.hang() }
+func (t *hangingTransmission) StopTorrents(ts []*tr.Torrent) error   { return t.hang() }
+func (t *hangingTransmission) SetLocation(ts []*tr.Torrent, location string, move bool) error {
+	return t.hang()
+}
+func (t *hangingTransmission) SetAltSpeedEnabled(on bool) error          { return t.hang() }
+func (t *hangingTransmission) ReannounceTorrents(ts []*tr.Torrent) error { return t.hang() }
+func (t *hangingTransmission) VerifyTorrents(ts []*tr.Torrent) error     { return t.hang() }
+
+func newTestMoveServer(t transmission) *MoveServer {
+	return &MoveServer{
+		p:           platform.New(platform.Config{}),
+		t:           t,
+		pathInfo:    map[string]*PathInfo{},
+		imported:    map[string]bool{},
+		diskHistory: map[string][]DiskSample{},
+		diskOver:    map[string]bool{},
+	}
+}
+
+func TestAssistantRunDoesNotHangOnTransmission(t *testing.T) {
+	ht := &hangingTransmission{release: make(chan struct{})}
+	defer close(ht.release)
+
+	s := newTestMoveServer(ht)
+	// Alt speed is on all day, so each run asks Transmission for it.
+	a, err := newAssistant(s, AssistantConfig{Schedule: []ScheduleWindow{
+		{Name: "am", Start: "00:00", End: "12:00", AltSpeed: true},
+		{Name: "pm", Start: "12:00", End: "00:00", AltSpeed: true},
+	}})
+	if err != nil {
+		t.Fatalf("newAssistant() failed: %v", err)
+	}
+	j, err := s.p.Cron.RegisterWithOptions("assistant", a.assist, cron.Every(time.Hour), cron.Options{
+		Timeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+
+	for run := 1; run <= 2; run++ {
+		start := time.Now()
+		if !j.RunNow() {
+			t.Fatalf("Run %d was skipped", run)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("Run %d took %v, want it to end on the timeout", run, d)
+		}
+		if h := j.History(); len(h) == 0 || !h[0].TimedOut {
+			t.Errorf("Run %d was not recorded as timed out", run)
+		}
+		// The job function returns on the timeout even though the
+		// Transmission call still hangs.
+		deadline := time.Now().Add(time.Second)
+		for j.IsRunning() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Run %d is still running after the timeout", run)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := atomic.LoadInt32(&ht.calls); got != int32(run) {
+			t.Errorf("Transmission calls after run %d = %d, want %d", run, got, run)
+		}
+	}
+}
+
+// startBlockingTransmission hangs in StartTorrents until released, other
+// calls return right away.
+type startBlockingTransmission struct {
+	hangingTransmission
+	started chan struct{}
+}
+
+func (t *startBlockingTransmission) StartTorrents(ts []*tr.Torrent) error {
+	close(t.started)
+	<-t.release
+	return nil
+}
+
+func TestAssistantDoesNotHoldLockDuringTransmissionCalls(t *testing.T) {
+	bt := &startBlockingTransmission{
+		hangingTransmission: hangingTransmission{release: make(chan struct{})},
+		started:             make(chan struct{}),
+	}
+	s := newTestMoveServer(bt)
+	a, err := newAssistant(s, AssistantConfig{})
+	if err != nil {
+		t.Fatalf("newAssistant() failed: %v", err)
+	}
+	s.Assistant = a
+	s.pathInfo["a"] = &PathInfo{
+		Name:           "a",
+		Path:           "/downloads/a",
+		AllowAssistant: true,
+		Torrent:        &tr.Torrent{Name: "a", HashString: "h", Status: tr.TR_STATUS_PAUSED},
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.assist(context.Background())
+	}()
+	select {
+	case <-bt.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The Assistant did not start the torrent")
+	}
+
+	// StartTorrents is blocked now, the MoveServer has to stay usable.
+	returned := make(chan struct{})
+	go func() {
+		s.GetPathInfoAndPathInfoHistory()
+		if err := s.SetPathMoveResult("/downloads/a", nil, ""); err != nil {
+			t.Errorf("SetPathMoveResult() failed: %v", err)
+		}
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Error("The MoveServer is locked while StartTorrents is blocked")
+	}
+
+	close(bt.release)
+	if err := <-done; err != nil {
+		t.Errorf("assist() failed: %v", err)
+	}
+	<-returned
+}
+
+func TestSimulateDoesNotChangeHealth(t *testing.T) {
+	s := newTestMoveServer(&hangingTransmission{release: make(chan struct{})})
+	a, err := newAssistant(s, AssistantConfig{Stall: StallConfig{Timeout: 60}})
+	if err != nil {
+		t.Fatalf("newAssistant() failed: %v", err)
+	}
+	downloading := func(name string) *PathInfo {
+		return &PathInfo{
+			Name:           name,
+			AllowAssistant: true,
+			// Status 4 is downloading.
+			Torrent: &tr.Torrent{Name: name, HashString: name, Status: 4, PercentDone: 0.5, DownloadedEver: 100},
+		}
+	}
+	s.pathInfo["stalled"] = downloading("stalled")
+	s.pathInfo["new"] = downloading("new")
+	lastProgress := time.Now().Add(-time.Hour)
+	a.health["stalled"] = &torrentHealth{lastDownloaded: 100, lastProgress: lastProgress, lastPeers: lastProgress}
+	a.health["gone"] = &torrentHealth{}
+
+	run := a.Simulate(context.Background())
+	if len(run.remedies) != 1 || run.remedies[0].pi.Name != "stalled" || run.remedies[0].remedy != RemedyReannounce {
+		t.Errorf("Simulate() decisions = %v, want a reannounce of the stalled torrent", run.Decisions)
+	}
+
+	if len(a.health) != 2 || a.health["gone"] == nil {
+		t.Errorf("Health after Simulate() = %v, want the stalled and gone items only", a.health)
+	}
+	if h := a.health["stalled"]; !h.lastProgress.Equal(lastProgress) || h.remedies != 0 {
+		t.Errorf("Health of the stalled item after Simulate() = %+v, want it unchanged", h)
+	}
+}
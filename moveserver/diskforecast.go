@@ -0,0 +1,169 @@
+package moveserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/HawkMachine/kodi_automation/platform/events"
+)
+
+const (
+	// A week of samples at the default refresh interval.
+	maxDiskSamples = 2016
+
+	// Fill rate is computed from samples within the window.
+	forecastWindow = 3 * 24 * time.Hour
+
+	// Samples have to span at least that long for a forecast.
+	minForecastSpan = time.Hour
+
+	defaultDiskFullThreshold = 95
+)
+
+// DiskSample is usage of a disk at a point in time.
+type DiskSample struct {
+	T     time.Time
+	Used  int64
+	Avail int64
+}
+
+// OverThreshold returns true if the disk is filled above its threshold.
+func (ds DiskStats) OverThreshold() bool {
+	return ds.Threshold > 0 && ds.PercentFull >= ds.Threshold
+}
+
+// FullInString returns a rough time until the disk is full, empty if it is
+// not filling up.
+func (ds DiskStats) FullInString() string {
+	switch {
+	case ds.FullIn <= 0:
+		return ""
+	case ds.FullIn < 48*time.Hour:
+		return fmt.Sprintf("~%d hours", int(ds.FullIn.Hours()+0.5))
+	default:
+		return fmt.Sprintf("~%d days", int(ds.FullIn.Hours()/24+0.5))
+	}
+}
+
+// fillRate returns the least squares slope of used bytes in bytes per second.
+// The second value is false if the samples do not span long enough.
+func fillRate(samples []DiskSample) (float64, bool) {
+	if len(samples) < 2 || samples[len(samples)-1].T.Sub(samples[0].T) < minForecastSpan {
+		return 0, false
+	}
+	t0 := samples[0].T
+	var sx, sy, sxx, sxy float64
+	for _, smp := range samples {
+		x := smp.T.Sub(t0).Seconds()
+		y := float64(smp.Used)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	n := float64(len(samples))
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0, false
+	}
+	return (n*sxy - sx*sy) / d, true
+}
+
+// diskThresholdFor returns the threshold of the disk with the given mount
+// point. Configured paths apply to the disk holding them, the longest one wins
+// if several are on the disk.
+func (s *MoveServer) diskThresholdFor(stats []DiskStats, mount string) int {
+	threshold := s.diskFullThreshold
+	best := ""
+	for path, t := range s.diskFullThresholds {
+		path = filepath.Clean(path)
+		if ds := diskStatsFor(stats, path); ds == nil || ds.Path != mount {
+			continue
+		}
+		if best == "" || len(path) > len(best) || (len(path) == len(best) && path < best) {
+			best = path
+			threshold = t
+		}
+	}
+	return threshold
+}
+
+// recordDiskSamplesLocked adds a sample per disk to the usage history and
+// fills in thresholds and forecasts of the stats. Disks getting above their
+// threshold are published once, until they get below it again.
+func (s *MoveServer) recordDiskSamplesLocked(stats []DiskStats, now time.Time) {
+	for i := range stats {
+		ds := &stats[i]
+		samples := append(s.diskHistory[ds.Path], DiskSample{T: now, Used: ds.Used, Avail: ds.Avail})
+		if len(samples) > maxDiskSamples {
+			samples = samples[len(samples)-maxDiskSamples:]
+		}
+		s.diskHistory[ds.Path] = samples
+
+		ds.Threshold = s.diskThresholdFor(stats, ds.Path)
+
+		first := len(samples)
+		for first > 0 && now.Sub(samples[first-1].T) <= forecastWindow {
+			first--
+		}
+		if rate, ok := fillRate(samples[first:]); ok && rate > 0 {
+			ds.FillRate = int64(rate * 24 * 3600)
+			ds.FullIn = time.Duration(float64(ds.Avail)/rate) * time.Second
+		}
+
+		over := ds.OverThreshold()
+		if over && !s.diskOver[ds.Path] {
+			s.publish(events.DiskThreshold, DiskThresholdEvent{
+				Path:        ds.Path,
+				PercentFull: ds.PercentFull,
+				Threshold:   ds.Threshold,
+				FullIn:      ds.FullInString(),
+			})
+		}
+		s.diskOver[ds.Path] = over
+	}
+}
+
+// GetDiskHistory returns usage samples of the disk with the given mount
+// point, oldest first.
+func (s *MoveServer) GetDiskHistory(mount string) []DiskSample {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]DiskSample(nil), s.diskHistory[mount]...)
+}
+
+// pathSize returns the total size of files under path.
+func pathSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// checkMoveSpaceLocked returns an error if the path would not fit on the disk
+// of the target directory or would fill it above its threshold. Moves and
+// hardlinks within a disk take no space.
+func (s *MoveServer) checkMoveSpaceLocked(pi *PathInfo, est *moveEstimate, to string, mode ImportMode) error {
+	dst := diskStatsFor(s.diskStats, to)
+	if dst == nil {
+		return nil
+	}
+	src := diskStatsFor(s.diskStats, pi.Path)
+	if mode != ImportModeCopy && src != nil && src.Path == dst.Path {
+		return nil
+	}
+	if est.sizeErr != nil {
+		return fmt.Errorf("Getting size of %s failed: %v", pi.Path, est.sizeErr)
+	}
+	return fitsDisk(dst, pi.Name, est.size)
+}
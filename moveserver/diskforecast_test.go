@@ -0,0 +1,41 @@
+package moveserver
+
+import "testing"
+
+func TestDiskThresholdForOverlappingPaths(t *testing.T) {
+	s := &MoveServer{
+		diskFullThreshold: 95,
+		diskFullThresholds: map[string]int{
+			"/mnt/a":              90,
+			"/mnt/a/movies":       80,
+			"/mnt/a/movies/4k/":   70,
+			"/mnt/a/tv":           85,
+			"/mnt/b/series":       60,
+			"/mnt/unknown/movies": 50,
+		},
+	}
+	stats := []DiskStats{{Path: "/"}, {Path: "/mnt/a"}, {Path: "/mnt/a/movies/4k"}, {Path: "/mnt/b"}}
+	for _, tc := range []struct {
+		mount string
+		want  int
+	}{
+		// /mnt/a and /mnt/a/movies are both on /mnt/a.
+		{"/mnt/a", 80},
+		{"/mnt/a/movies/4k", 70},
+		{"/mnt/b", 60},
+		// /mnt/unknown is on /.
+		{"/", 50},
+	} {
+		// Map iteration order varies between runs.
+		for i := 0; i < 20; i++ {
+			if got := s.diskThresholdFor(stats, tc.mount); got != tc.want {
+				t.Fatalf("diskThresholdFor(%s) = %d, want %d", tc.mount, got, tc.want)
+			}
+		}
+	}
+
+	s.diskFullThresholds = nil
+	if got := s.diskThresholdFor(stats, "/mnt/a"); got != 95 {
+		t.Errorf("diskThresholdFor(/mnt/a) without overrides = %d, want 95", got)
+	}
+}
@@ -0,0 +1,135 @@
+package moveserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// mountPoint is a mounted filesystem.
+type mountPoint struct {
+	Path   string
+	FsType string
+}
+
+// isUnder returns true if path is dir or inside of it. Unlike a plain prefix
+// match /mnt/media2 is not under /mnt/media.
+func isUnder(path, dir string) bool {
+	path = filepath.Clean(path)
+	dir = filepath.Clean(dir)
+	if path == dir || dir == "/" {
+		return true
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
+
+// resolveMountPoint returns the mount point holding path, nil if none does.
+func resolveMountPoint(mounts []mountPoint, path string) *mountPoint {
+	var best *mountPoint
+	for i := range mounts {
+		m := &mounts[i]
+		if !isUnder(path, m.Path) {
+			continue
+		}
+		if best == nil || len(m.Path) > len(best.Path) {
+			best = m
+		}
+	}
+	return best
+}
+
+// diskStatsTargets returns the source directory and the configured targets
+// disk stats are collected for.
+func (s *MoveServer) diskStatsTargets() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	set := map[string]bool{}
+	for target := range s.moviesTargets {
+		set[target] = true
+	}
+	for target := range s.seriesTargets {
+		set[target] = true
+	}
+	for target := range s.importModes {
+		set[target] = true
+	}
+	for _, pool := range s.pools {
+		for _, target := range pool.Targets {
+			set[target] = true
+		}
+	}
+	if s.defaultMoveTarget != "" {
+		set[s.defaultMoveTarget] = true
+	}
+	// Downloads fill the disk of the source directory.
+	if s.sourceDir != "" {
+		set[s.sourceDir] = true
+	}
+	var targets []string
+	for target := range set {
+		targets = append(targets, filepath.Clean(target))
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// updateDiskStats collects stats of the filesystems holding the targets. Each
+// filesystem is reported once, under its mount point.
+func updateDiskStats(ctx context.Context, s *MoveServer) error {
+	mounts, err := readMounts()
+	if err != nil {
+		// Without mounts each target is reported on its own.
+		s.Log("UpdateDiskStats", fmt.Sprintf("Reading mounts failed: %v", err))
+	}
+
+	ds := []DiskStats{}
+	seen := map[string]bool{}
+	var errs []string
+	for _, target := range s.diskStatsTargets() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		path, fsType := target, ""
+		if m := resolveMountPoint(mounts, target); m != nil {
+			path, fsType = m.Path, m.FsType
+		}
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		st, err := statDisk(path)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+		st.Path = path
+		st.FsType = fsType
+		ds = append(ds, st)
+	}
+
+	log.Print("Disk stats:")
+	for _, x := range ds {
+		log.Printf("   %#v", x)
+	}
+	s.setDiskStats(ds)
+
+	if len(errs) != 0 {
+		s.Log("UpdateDiskStats", fmt.Sprintf("statfs failed: %s", strings.Join(errs, "; ")))
+		return fmt.Errorf("Getting disk stats failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// percent returns used as a percentage of used+avail rounded up, same as df.
+func percent(used, avail int64) int {
+	total := used + avail
+	if total <= 0 {
+		return 0
+	}
+	return int((used*100 + total - 1) / total)
+}
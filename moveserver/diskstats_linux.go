@@ -0,0 +1,90 @@
+//go:build linux
+// +build linux
+
+package moveserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// readMounts parses /proc/self/mountinfo.
+func readMounts() ([]mountPoint, error) {
+	b, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	var mounts []mountPoint
+	for _, line := range strings.Split(string(b), "\n") {
+		// Optional fields end with a "-" separator, the filesystem type
+		// follows it.
+		fields := strings.Fields(line)
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 5 || sep < 0 || sep+1 >= len(fields) {
+			continue
+		}
+		mounts = append(mounts, mountPoint{
+			Path:   unescapeMountPath(fields[4]),
+			FsType: fields[sep+1],
+		})
+	}
+	if len(mounts) == 0 {
+		return nil, fmt.Errorf("No mounts found in /proc/self/mountinfo")
+	}
+	return mounts, nil
+}
+
+// unescapeMountPath decodes octal escapes like \040 for a space.
+func unescapeMountPath(p string) string {
+	if !strings.Contains(p, `\`) {
+		return p
+	}
+	var sb strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+4 <= len(p) {
+			if c, err := strconv.ParseUint(p[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(p[i])
+	}
+	return sb.String()
+}
+
+// statDisk returns space and inode usage of the filesystem holding path.
+func statDisk(path string) (DiskStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return DiskStats{}, err
+	}
+	bsize := int64(st.Frsize)
+	if bsize <= 0 {
+		bsize = int64(st.Bsize)
+	}
+	size := int64(st.Blocks) * bsize
+	used := (int64(st.Blocks) - int64(st.Bfree)) * bsize
+	avail := int64(st.Bavail) * bsize
+	inodes := int64(st.Files)
+	inodesFree := int64(st.Ffree)
+	return DiskStats{
+		Size:              size,
+		Used:              used,
+		Avail:             avail,
+		PercentFull:       percent(used, avail),
+		Inodes:            inodes,
+		InodesUsed:        inodes - inodesFree,
+		InodesFree:        inodesFree,
+		PercentInodesFull: percent(inodes-inodesFree, inodesFree),
+	}, nil
+}
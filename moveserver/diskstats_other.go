@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package moveserver
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func readMounts() ([]mountPoint, error) {
+	return nil, fmt.Errorf("Reading mounts is not supported on %s", runtime.GOOS)
+}
+
+func statDisk(path string) (DiskStats, error) {
+	return DiskStats{}, fmt.Errorf("Disk stats are not supported on %s", runtime.GOOS)
+}
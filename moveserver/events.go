@@ -0,0 +1,79 @@
+package moveserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/HawkMachine/kodi_automation/platform/events"
+	tr "github.com/HawkMachine/transmission_go_api"
+)
+
+// PathEvent is the data of item and move events.
+type PathEvent struct {
+	Name   string     `json:"name"`
+	Path   string     `json:"path,omitempty"`
+	Target string     `json:"target,omitempty"`
+	Mode   ImportMode `json:"mode,omitempty"`
+	Status string     `json:"status,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// TorrentStateEvent is the data of events.TorrentState events.
+type TorrentStateEvent struct {
+	Name        string  `json:"name"`
+	State       string  `json:"state"`
+	PercentDone float64 `json:"percent_done"`
+}
+
+// AssistantRunEvent is the data of events.AssistantRun events.
+type AssistantRunEvent struct {
+	T         time.Time `json:"t"`
+	DryRun    bool      `json:"dry_run"`
+	Decisions []string  `json:"decisions"`
+}
+
+// LogMessageEvent is the data of events.LogMessage events.
+type LogMessageEvent struct {
+	Type string `json:"type"`
+	Msg  string `json:"msg"`
+}
+
+// DiskThresholdEvent is the data of events.DiskThreshold events, published
+// when a disk gets filled above its threshold.
+type DiskThresholdEvent struct {
+	Path        string `json:"path"`
+	PercentFull int    `json:"percent_full"`
+	Threshold   int    `json:"threshold"`
+	FullIn      string `json:"full_in,omitempty"`
+}
+
+func (s *MoveServer) publish(t events.Type, data interface{}) {
+	s.p.Events.Publish("moveserver", t, data)
+}
+
+func (s *MoveServer) publishPath(t events.Type, pi *PathInfo) {
+	ev := PathEvent{
+		Name:   pi.Name,
+		Path:   pi.Path,
+		Target: pi.MoveInfo.Target,
+		Mode:   pi.MoveInfo.Mode,
+		Status: pi.MoveInfo.Status,
+	}
+	if pi.MoveInfo.LastError != nil {
+		ev.Error = pi.MoveInfo.LastError.Error()
+	}
+	s.publish(t, ev)
+}
+
+// publishTorrentChange publishes a torrent state event if the status of the
+// torrent changed or it completed.
+func (s *MoveServer) publishTorrentChange(old, t *tr.Torrent) {
+	if old != nil && old.Status == t.Status && (old.PercentDone == 1.0) == (t.PercentDone == 1.0) {
+		return
+	}
+	state := fmt.Sprintf("status %v", t.Status)
+	if t.PercentDone == 1.0 {
+		state = fmt.Sprintf("complete, status %v", t.Status)
+	}
+	s.publish(events.TorrentState, TorrentStateEvent{Name: t.Name, State: state, PercentDone: t.PercentDone})
+}
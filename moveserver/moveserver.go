@@ -1,19 +1,21 @@
 package moveserver
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/HawkMachine/kodi_automation/platform"
+	"github.com/HawkMachine/kodi_automation/platform/cron"
+	"github.com/HawkMachine/kodi_automation/platform/events"
 	"github.com/HawkMachine/kodi_automation/utils/collections"
 
 	kd "github.com/HawkMachine/kodi_go_api/v6/kodi"
@@ -47,90 +49,127 @@ func directoryListing(dirname string, levels int, dirsOnly bool) ([]string, erro
 	return res, nil
 }
 
+// ImportMode describes how a path is brought into a library target.
+type ImportMode string
+
+const (
+	// ImportModeMove moves the data with mv. The torrent has to be removed
+	// from Transmission because its data is gone from the download directory.
+	ImportModeMove ImportMode = "move"
+
+	// ImportModeHardlink hardlinks the files into the target. Source and target
+	// have to be on the same filesystem. The torrent keeps seeding.
+	ImportModeHardlink ImportMode = "hardlink"
+
+	// ImportModeCopy copies the data into the target. The torrent keeps
+	// seeding.
+	ImportModeCopy ImportMode = "copy"
+
+	// ImportModeTransmission asks Transmission to relocate the data with
+	// torrent-set-location. The torrent keeps seeding from the target.
+	ImportModeTransmission ImportMode = "transmission"
+)
+
+// KeepsTorrent returns true if the torrent stays in Transmission after import.
+func (m ImportMode) KeepsTorrent() bool {
+	return m == ImportModeHardlink || m == ImportModeCopy || m == ImportModeTransmission
+}
+
+func parseImportMode(s string) (ImportMode, error) {
+	switch m := ImportMode(s); m {
+	case ImportModeMove, ImportModeHardlink, ImportModeCopy, ImportModeTransmission:
+		return m, nil
+	case "":
+		return ImportModeMove, nil
+	}
+	return "", fmt.Errorf("Unknown import mode %q", s)
+}
+
 type MoveRequest struct {
-	Path string
-	To   string
+	Path    string
+	To      string
+	Mode    ImportMode
+	Torrent *tr.Torrent // Needed by ImportModeTransmission.
+}
+
+func importCommand(req MoveRequest) *exec.Cmd {
+	switch req.Mode {
+	case ImportModeHardlink:
+		return exec.Command("cp", "-al", req.Path, req.To)
+	case ImportModeCopy:
+		return exec.Command("cp", "-a", req.Path, req.To)
+	}
+	return exec.Command("mv", req.Path, req.To)
 }
 
 type MoveListenerRequest struct {
 	Request MoveRequest
 }
 
-func moveListener(s *MoveServer, ch chan MoveListenerRequest) {
-	for {
-		req := <-ch
-		log.Printf("Received move requests %v", req)
+// Interval of move progress updates.
+const moveProgressInterval = 5 * time.Second
+
+// runImportCommand runs the import command and reports the progress as the
+// move status. It returns the combined output of the command.
+func runImportCommand(s *MoveServer, req MoveRequest) (string, error) {
+	size, _ := pathSize(req.Path)
 
-		bytes, err := exec.Command("mv", req.Request.Path, req.Request.To).CombinedOutput()
-		log.Printf("Move result: err: %v; output: %s", err, string(bytes))
-		s.SetPathMoveResult(req.Request.Path, err, string(bytes))
+	var out bytes.Buffer
+	cmd := importCommand(req)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		return "", err
 	}
-}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
 
-func updateDiskStats(s *MoveServer) {
-	regex := regexp.MustCompile(`([^\s]+)\s+([^\s]+)\s+(\d+)\s+(\d+)\s+(\d+)\s+(\d+)%`)
-	// Results in MB
-	bytes, err := exec.Command("df", "-B", "1", "--output=target,fstype,size,used,avail,pcent").Output()
-	if err != nil {
-		s.Log("UpdateDiskStats", fmt.Sprintf("df failed: %v", err))
-		s.setDiskStats(nil)
-	} else {
-		ds := []DiskStats{}
-		output := string(bytes)
-		for _, line := range strings.Split(string(output), "\n")[1:] {
-			grs := regex.FindStringSubmatch(line)
-			if grs == nil {
-				continue
-			}
-			size, err := strconv.ParseInt(grs[3], 10, 64)
-			if err != nil {
-				continue
-			}
-			used, err := strconv.ParseInt(grs[4], 10, 64)
-			if err != nil {
-				continue
-			}
-			avail, err := strconv.ParseInt(grs[5], 10, 64)
-			if err != nil {
-				continue
-			}
-			pFull, err := strconv.Atoi(grs[6])
-			if err != nil {
+	ticker := time.NewTicker(moveProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return out.String(), err
+		case <-ticker.C:
+			if size <= 0 {
 				continue
 			}
-			if grs != nil {
-				ds = append(ds, DiskStats{
-					Path:        grs[1],
-					FsType:      grs[2],
-					Size:        size,
-					Used:        used,
-					Avail:       avail,
-					PercentFull: pFull,
-				})
+			if copied, err := pathSize(req.To); err == nil {
+				s.setPathMoveStatus(req.Path, fmt.Sprintf("Running %s, %d%% done", req.Mode, copied*100/size))
 			}
 		}
-		log.Print("Disk stats:")
-		for _, x := range ds {
-			log.Printf("   %#v", x)
-		}
-		s.setDiskStats(ds)
 	}
 }
 
-func diskStatsUpdater(s *MoveServer, d time.Duration) {
+func moveListener(s *MoveServer, ch chan MoveListenerRequest) {
 	for {
-		updateDiskStats(s)
-		time.Sleep(d)
+		req := <-ch
+		log.Printf("Received move requests %v", req)
+		s.moveStarted(req.Request.Path)
+
+		if req.Request.Mode == ImportModeTransmission {
+			err := relocateTorrent(s, req.Request)
+			log.Printf("Move result (%s): err: %v", req.Request.Mode, err)
+			s.SetPathMoveResult(req.Request.Path, err, "")
+			continue
+		}
+		output, err := runImportCommand(s, req.Request)
+		log.Printf("Move result (%s): err: %v; output: %s", req.Request.Mode, err, output)
+		s.SetPathMoveResult(req.Request.Path, err, output)
 	}
 }
 
-func updateCache(s *MoveServer) {
+func updateCache(ctx context.Context, s *MoveServer) error {
 	log.Printf("Updating cached info.")
+	var errs []string
 
 	// List the files in the transmission directory.
 	sourceDirListing, err := directoryListing(s.sourceDir, 1, false)
 	if err != nil {
 		s.Log("UpdateCache", fmt.Sprintf("Listing source target error: %v", err))
+		errs = append(errs, fmt.Sprintf("listing source dir: %v", err))
 		sourceDirListing = nil
 	}
 
@@ -146,34 +185,41 @@ func updateCache(s *MoveServer) {
 	}
 
 	// List all torrents from Transmission.
-	torrentsList, err := s.t.ListAll()
+	// The list is only read once the call returned, a call abandoned on ctx
+	// done may still write it.
+	var listed, torrentsList []*tr.Torrent
+	err = callWithContext(ctx, func() error {
+		var err error
+		listed, err = s.t.ListAll()
+		return err
+	})
 	if err != nil {
 		s.Log("UpdateCache", fmt.Sprintf("Getting torrents info error: %v", err))
-		torrentsList = nil
+		errs = append(errs, fmt.Sprintf("listing torrents: %v", err))
+	} else {
+		torrentsList = listed
 	}
 
-	// Get transmission info.
-
+	// Do not overwrite the cache with results of a run that took too long.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	s.setCachedInfo(sourceDirListing, torrentsList, suggestionsList)
-}
 
-func cacheUpdater(s *MoveServer, d time.Duration) {
-	for {
-		updateCache(s)
-		time.Sleep(d)
+	if len(errs) != 0 {
+		return fmt.Errorf("Updating cache failed: %s", strings.Join(errs, "; "))
 	}
+	return nil
 }
 
+// UpdateCacheAsync runs the cache update cron job now.
 func (s *MoveServer) UpdateCacheAsync() {
-	go func() {
-		updateCache(s)
-	}()
+	go s.cacheJob.RunNow()
 }
 
+// UpdateDiskStatsAsync runs the disk stats cron job now.
 func (s *MoveServer) UpdateDiskStatsAsync() {
-	go func() {
-		updateDiskStats(s)
-	}()
+	go s.diskStatsJob.RunNow()
 }
 
 // LogMessage encapsulates message with a type and time.
@@ -187,6 +233,8 @@ type LogMessage struct {
 type PathMoveInfo struct {
 	Moving          bool
 	Target          string
+	Mode            ImportMode
+	Status          string // Progress reported while the move is running.
 	LastError       error
 	LastErrorOutput string
 }
@@ -197,18 +245,29 @@ type PathInfo struct {
 	Path           string // Present if found on disk.
 	AllowMove      bool
 	AllowAssistant bool
+	Imported       bool // Copied or hardlinked into a target, kept for seeding.
+	Priority       int  // Higher priority torrents are started first by the Assistant.
 	MoveInfo       PathMoveInfo
 	Torrent        *tr.Torrent // Present if found in torrent.
 	MoveTo         string      // Path where this should be moved, can be empty
 }
 
 type DiskStats struct {
-	Path        string
+	Path        string // Mount point.
 	Size        int64
 	Used        int64
 	Avail       int64
 	PercentFull int
 	FsType      string
+
+	Inodes            int64
+	InodesUsed        int64
+	InodesFree        int64
+	PercentInodesFull int
+
+	Threshold int           // Percent full above which the disk is not filled further.
+	FillRate  int64         // Bytes per day, zero if not filling up.
+	FullIn    time.Duration // Forecast, zero if not filling up.
 }
 
 type MoveServerConfig struct {
@@ -218,11 +277,37 @@ type MoveServerConfig struct {
 	MaxMvCommands     int      `json:"max_mv_commands"`
 	MvBufferSize      int      `json:"mv_buffer_size"`
 	DefaultMoveTarget string   `json:"default_move_target"`
+
+	// Import mode used for targets without an entry in ImportModes. One of
+	// "move", "hardlink", "copy" or "transmission", defaults to "move".
+	DefaultImportMode string `json:"default_import_mode"`
+
+	// Import mode per target directory. The longest matching target is used,
+	// so subdirectories of a target inherit its mode.
+	ImportModes map[string]string `json:"import_modes"`
+
+	// Seconds between cache and disk stats refreshes, both default to 5
+	// minutes. The source directory is also watched for changes, the cache
+	// refresh catches anything the watch missed and refreshes torrents.
+	CacheRefreshInterval     int `json:"cache_refresh_interval"`
+	DiskStatsRefreshInterval int `json:"disk_stats_refresh_interval"`
+
+	// Percent a disk may be filled to, defaults to 95. Above it the Assistant
+	// does not start downloads and moves that would exceed it are refused.
+	// DiskFullThresholds overrides it for the disks holding the given paths.
+	DiskFullThreshold  int            `json:"disk_full_threshold"`
+	DiskFullThresholds map[string]int `json:"disk_full_thresholds"`
+
+	// Pools of targets by name. Items are moved to a pool with a move target
+	// like "pool:movies" and land in one of its targets.
+	Pools map[string]PoolConfig `json:"pools"`
+
+	Assistant AssistantConfig `json:"assistant"`
 }
 
 type MoveServer struct {
 	p *platform.Platform
-	t *tr.Transmission
+	t transmission
 	k *kd.Kodi
 
 	// Directory to scan
@@ -250,12 +335,35 @@ type MoveServer struct {
 	cacheRefreshed  time.Time
 	refreshDuration time.Duration
 
+	// Cron jobs refreshing the cache and disk stats.
+	cacheJob                 *cron.CronJob
+	diskStatsJob             *cron.CronJob
+	diskStatsRefreshDuration time.Duration
+
 	// Default path where torrents are moved to.
 	defaultMoveTarget string
 
+	// Target pools by name.
+	pools map[string]*targetPool
+
+	// Import modes.
+	defaultImportMode ImportMode
+	importModes       map[string]ImportMode
+
+	// Names of paths imported with a mode that keeps the torrent. They stay in
+	// pathInfo while they seed and must not be imported again.
+	imported map[string]bool
+
 	// Disk stats
 	diskStats []DiskStats
 
+	// Usage samples by mount point, thresholds and mount points of disks
+	// above their threshold.
+	diskHistory        map[string][]DiskSample
+	diskFullThreshold  int
+	diskFullThresholds map[string]int
+	diskOver           map[string]bool
+
 	// Move channels.
 	moveChannel chan MoveListenerRequest
 
@@ -270,6 +378,25 @@ type MoveServer struct {
 }
 
 func New(p *platform.Platform, c MoveServerConfig) (*MoveServer, error) {
+	defaultImportMode, err := parseImportMode(c.DefaultImportMode)
+	if err != nil {
+		return nil, err
+	}
+	importModes := map[string]ImportMode{}
+	for target, mode := range c.ImportModes {
+		m, err := parseImportMode(mode)
+		if err != nil {
+			return nil, fmt.Errorf("Import mode for %s: %v", target, err)
+		}
+		importModes[filepath.Clean(target)] = m
+	}
+	pools, err := parsePools(c.Pools, func(target string) ImportMode {
+		return targetImportMode(importModes, defaultImportMode, target)
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	t, _ := tr.New(
 		p.Config.Transmission.Address,
 		p.Config.Transmission.Username,
@@ -287,31 +414,70 @@ func New(p *platform.Platform, c MoveServerConfig) (*MoveServer, error) {
 		seriesTargets:     collections.NewStringsSet(c.SeriesTargets),
 		pathInfoHistory:   []*PathInfo{},
 		pathInfo:          map[string]*PathInfo{},
-		refreshDuration:   5 * time.Minute,
+		refreshDuration:   refreshInterval(c.CacheRefreshInterval),
 		cacheRefreshed:    time.Now(),
 		moveChannel:       make(chan MoveListenerRequest, c.MvBufferSize),
 		messages:          []*LogMessage{},
 		lock:              sync.Mutex{},
 		messagesLock:      sync.Mutex{},
 		defaultMoveTarget: c.DefaultMoveTarget,
+		defaultImportMode: defaultImportMode,
+		importModes:       importModes,
+		pools:             pools,
+		imported:          map[string]bool{},
+
+		diskStatsRefreshDuration: refreshInterval(c.DiskStatsRefreshInterval),
+		diskHistory:              map[string][]DiskSample{},
+		diskFullThreshold:        c.DiskFullThreshold,
+		diskFullThresholds:       c.DiskFullThresholds,
+		diskOver:                 map[string]bool{},
+	}
+	if s.diskFullThreshold <= 0 {
+		s.diskFullThreshold = defaultDiskFullThreshold
 	}
 
 	for i := 0; i < c.MaxMvCommands; i++ {
 		go moveListener(s, s.moveChannel)
 	}
-	go cacheUpdater(s, 5*time.Minute)
-	go diskStatsUpdater(s, 5*time.Minute)
+	// Both run on start to fill the cache and disk stats right away.
+	s.cacheJob, err = p.Cron.RegisterWithOptions("cache_update", func(ctx context.Context) error {
+		return updateCache(ctx, s)
+	}, cron.Every(s.refreshDuration), cron.Options{RunOnStart: true})
+	if err != nil {
+		return nil, err
+	}
+	s.diskStatsJob, err = p.Cron.RegisterWithOptions("disk_stats_update", func(ctx context.Context) error {
+		return updateDiskStats(ctx, s)
+	}, cron.Every(s.diskStatsRefreshDuration), cron.Options{RunOnStart: true})
+	if err != nil {
+		return nil, err
+	}
+	if s.sourceDir != "" {
+		go watchSourceDir(s)
+	}
 
 	// If we have a target path of not it makes sense to have an assitant.  It
 	// will start torrents and move them to their destination when they're
 	// finished.
-	s.Assistant = newAssistant(s)
+	s.Assistant, err = newAssistant(s, c.Assistant)
+	if err != nil {
+		return nil, err
+	}
 	s.Assistant.Enable()
 	s.Log("moveserver", fmt.Sprintf("Assistant created, default target path %s", s.defaultMoveTarget))
 
 	return s, nil
 }
 
+// refreshInterval returns the duration of an interval in seconds, 5 minutes
+// if not set.
+func refreshInterval(seconds int) time.Duration {
+	if seconds <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(seconds) * time.Second
+}
+
 func (s *MoveServer) GetCacheRefreshed() time.Time {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -326,12 +492,23 @@ func (s *MoveServer) GetMoveTargets() []string {
 	return s.moveTargets_sorted
 }
 
+// GetPathInfoAndPathInfoHistory returns copies of the path infos and the path
+// info history, safe to use without the lock.
 func (s *MoveServer) GetPathInfoAndPathInfoHistory() (map[string]*PathInfo, []*PathInfo) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	// TODO: we should create a copy here.
-	return s.pathInfo, s.pathInfoHistory
+	pathInfo := make(map[string]*PathInfo, len(s.pathInfo))
+	for name, pi := range s.pathInfo {
+		c := *pi
+		pathInfo[name] = &c
+	}
+	history := make([]*PathInfo, 0, len(s.pathInfoHistory))
+	for _, pi := range s.pathInfoHistory {
+		c := *pi
+		history = append(history, &c)
+	}
+	return pathInfo, history
 }
 
 func (s *MoveServer) GetMvBuffSizeAndElems() (int, int) {
@@ -365,6 +542,7 @@ func (s *MoveServer) Log(tp, msg string) {
 		Msg:  msg,
 	}
 	log.Printf("%s: %s", tp, msg)
+	s.publish(events.LogMessage, LogMessageEvent{Type: tp, Msg: msg})
 	if len(s.messages) >= 5000 {
 		s.messages = s.messages[:5000]
 	}
@@ -383,7 +561,7 @@ func (s *MoveServer) SetMovePath(name, move_to string) error {
 	return nil
 }
 
-func (s *MoveServer) Move(name string) error {
+func (s *MoveServer) SetPriority(name string, priority int) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
@@ -391,7 +569,30 @@ func (s *MoveServer) Move(name string) error {
 	if !ok {
 		return fmt.Errorf("Item %s not found.", name)
 	}
-	return s.moveLocked(pi)
+	pi.Priority = priority
+	return nil
+}
+
+func (s *MoveServer) Move(name string) error {
+	s.lock.Lock()
+	pi, ok := s.pathInfo[name]
+	if !ok {
+		s.lock.Unlock()
+		return fmt.Errorf("Item %s not found.", name)
+	}
+	snap := *pi
+	s.lock.Unlock()
+
+	est := s.estimateMove(&snap)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pi, ok = s.pathInfo[name]
+	if !ok {
+		return fmt.Errorf("Item %s not found.", name)
+	}
+	return s.moveLocked(pi, est)
 }
 
 func (s *MoveServer) validateMovePathInfo(pi *PathInfo) error {
@@ -402,7 +603,12 @@ func (s *MoveServer) validateMovePathInfo(pi *PathInfo) error {
 		return fmt.Errorf("Moving the path is not allowed")
 	}
 	if pi.MoveInfo.Moving {
-		return fmt.Errorf("Requested move path %s is currently in move to %s", pi.MoveInfo.Target)
+		return fmt.Errorf("Requested move path %s is currently in move to %s", pi.Name, pi.MoveInfo.Target)
+	}
+	if s.imported[pi.Name] {
+		// The data was hardlinked or copied and the torrent keeps seeding,
+		// importing it again would duplicate it in the library.
+		return fmt.Errorf("%s was already imported", pi.Name)
 	}
 	if _, err := os.Stat(pi.Path); err != nil && os.IsNotExist(err) {
 		return err
@@ -420,15 +626,64 @@ func (s *MoveServer) validateMoveTargetPath(path string) error {
 	return nil
 }
 
-func (s *MoveServer) moveLocked(pi *PathInfo) error {
+// importModeFor returns the import mode configured for the target. The mode of
+// the longest configured target containing it is used.
+func (s *MoveServer) importModeFor(target string) ImportMode {
+	// Targets of a pool share the import mode, parsePools checks it.
+	if pool, _ := s.poolFor(target); pool != nil {
+		target = pool.Targets[0]
+	}
+	return targetImportMode(s.importModes, s.defaultImportMode, target)
+}
+
+// targetImportMode returns the mode of the longest target in modes containing
+// target, def if there is none.
+func targetImportMode(modes map[string]ImportMode, def ImportMode, target string) ImportMode {
+	target = filepath.Clean(target)
+	mode := def
+	best := -1
+	for t, m := range modes {
+		if target != t && !strings.HasPrefix(target, t+string(filepath.Separator)) {
+			continue
+		}
+		if len(t) > best {
+			best = len(t)
+			mode = m
+		}
+	}
+	return mode
+}
+
+// moveLocked requests a move of the item. est has to be computed with
+// estimateMove before taking the lock.
+func (s *MoveServer) moveLocked(pi *PathInfo, est *moveEstimate) error {
 	// Source path verification
 	if err := s.validateMovePathInfo(pi); err != nil {
 		pi.MoveInfo.LastError = err
 		return err
 	}
+	if est.path != pi.Path {
+		err := fmt.Errorf("Path of %s changed while preparing the move", pi.Name)
+		pi.MoveInfo.LastError = err
+		return err
+	}
 
-	// Target path verification
-	if err := s.validateMoveTargetPath(pi.MoveTo); err != nil {
+	// Target path verification, pools resolve to one of their targets.
+	to, err := s.resolveMoveTargetLocked(pi, est)
+	if err != nil {
+		pi.MoveInfo.LastError = err
+		return err
+	}
+
+	mode := s.importModeFor(to)
+	if mode == ImportModeTransmission && pi.Torrent == nil {
+		err := fmt.Errorf("Import mode %s requires torrent info for %s", mode, pi.Name)
+		pi.MoveInfo.LastError = err
+		return err
+	}
+
+	// Space verification.
+	if err := s.checkMoveSpaceLocked(pi, est, to, mode); err != nil {
 		pi.MoveInfo.LastError = err
 		return err
 	}
@@ -439,14 +694,18 @@ func (s *MoveServer) moveLocked(pi *PathInfo) error {
 	}
 
 	// Actually making a move.
-	target := filepath.Join(pi.MoveTo, filepath.Base(pi.Path))
+	target := filepath.Join(to, filepath.Base(pi.Path))
 	pi.MoveInfo.Moving = true
 	pi.MoveInfo.Target = target
+	pi.MoveInfo.Mode = mode
+	pi.MoveInfo.Status = "Queued"
 
 	s.moveChannel <- MoveListenerRequest{
 		Request: MoveRequest{
-			Path: pi.Path,
-			To:   target,
+			Path:    pi.Path,
+			To:      target,
+			Mode:    mode,
+			Torrent: pi.Torrent,
 		},
 	}
 	return nil
@@ -466,10 +725,26 @@ func (s *MoveServer) SetPathMoveResult(path string, err error, output string) er
 	pi.MoveInfo = PathMoveInfo{
 		Moving:          false,
 		Target:          pi.MoveInfo.Target,
+		Mode:            pi.MoveInfo.Mode,
 		LastError:       err,
 		LastErrorOutput: output,
 	}
-	if err == nil {
+	if s.Assistant != nil {
+		s.Assistant.recordAction(name, fmt.Sprintf("Move (%s) to %s finished", pi.MoveInfo.Mode, pi.MoveInfo.Target), err)
+	}
+	if err == nil && pi.MoveInfo.Mode.KeepsTorrent() {
+		// Successful import, the torrent stays in Transmission and keeps
+		// seeding.
+		imported := *pi
+		s.pathInfoHistory = append(s.pathInfoHistory, &imported)
+		pi.Imported = true
+		if pi.MoveInfo.Mode == ImportModeTransmission {
+			// Transmission moved the data out of the source directory.
+			pi.Path = ""
+		}
+		s.imported[name] = true
+		s.Log("MoveResult", fmt.Sprintf("Successfully imported (%s) %s to %s", pi.MoveInfo.Mode, pi.Name, pi.MoveInfo.Target))
+	} else if err == nil {
 		// Successful move.
 		delete(s.pathInfo, name)
 		s.pathInfoHistory = append(s.pathInfoHistory, pi)
@@ -478,9 +753,32 @@ func (s *MoveServer) SetPathMoveResult(path string, err error, output string) er
 		// Unsuccessful move.
 		pi.MoveInfo.Target = ""
 	}
+	s.publishPath(events.MoveFinished, pi)
 	return nil
 }
 
+// setPathMoveStatus updates the progress of a running move.
+func (s *MoveServer) setPathMoveStatus(path string, status string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if pi, ok := s.pathInfo[filepath.Base(path)]; ok && pi.MoveInfo.Moving {
+		pi.MoveInfo.Status = status
+		s.publishPath(events.MoveProgress, pi)
+	}
+}
+
+// moveStarted is called when a move listener picks up the move request.
+func (s *MoveServer) moveStarted(path string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if pi, ok := s.pathInfo[filepath.Base(path)]; ok && pi.MoveInfo.Moving {
+		pi.MoveInfo.Status = fmt.Sprintf("Running %s", pi.MoveInfo.Mode)
+		s.publishPath(events.MoveStarted, pi)
+	}
+}
+
 func (s *MoveServer) loadTorrentsInfo() ([]*tr.Torrent, error) {
 	// In the future I need more to ask both - transmission and scan local disk.
 	return s.t.ListAll()
@@ -494,6 +792,15 @@ func getSeriesTargetListing(seriesTarget string) ([]string, error) {
 	return listing, nil
 }
 
+// allowMove returns whether the path can be moved. Paths of torrents can be
+// moved once the download is complete.
+func allowMove(pi *PathInfo) bool {
+	if pi.Torrent != nil {
+		return pi.Torrent.PercentDone == 1.0 && !pi.MoveInfo.Moving
+	}
+	return pi.Path != ""
+}
+
 // setCachedInfo updates cahed infor on MoveServer. paths is a list of paths in
 // the source dir, ntis is the new transmission info, nstl is the new
 // series target listing.
@@ -524,6 +831,11 @@ func (s *MoveServer) setCachedInfo(sourceDirListing []string, torrenstListing []
 
 	// Add new path info from the transmission data that was found.
 	for _, t := range torrenstListing {
+		if opi, ok := oldPathInfo[t.Name]; ok {
+			s.publishTorrentChange(opi.Torrent, t)
+		} else {
+			s.publishTorrentChange(nil, t)
+		}
 		pi, ok := newPathInfo[t.Name]
 		if !ok {
 			newPathInfo[t.Name] = &PathInfo{
@@ -545,16 +857,22 @@ func (s *MoveServer) setCachedInfo(sourceDirListing []string, torrenstListing []
 			pi.AllowMove = opi.AllowMove
 			pi.MoveInfo = opi.MoveInfo
 			pi.MoveTo = opi.MoveTo
+			pi.Priority = opi.Priority
 		} else {
 			s.pathInfoDisappeared = append(s.pathInfoDisappeared, opi)
+			delete(s.imported, opi.Name)
+			s.publishPath(events.ItemDisappeared, opi)
 		}
 	}
+	for _, pi := range newPathInfo {
+		pi.Imported = s.imported[pi.Name]
+	}
 
 	// Update AllowMove
 	for _, pi := range newPathInfo {
-		pi.AllowMove = pi.Path != ""
-		if pi.Torrent != nil {
-			pi.AllowMove = pi.Torrent.PercentDone == 1.0 && !pi.MoveInfo.Moving
+		pi.AllowMove = allowMove(pi)
+		if _, ok := oldPathInfo[pi.Name]; !ok {
+			s.publishPath(events.ItemAppeared, pi)
 		}
 	}
 
@@ -581,22 +899,38 @@ func (s *MoveServer) setCachedInfo(sourceDirListing []string, torrenstListing []
 	}
 	moveTargets_sorted = append(moveTargets_sorted, seriesTargets_sorted...)
 
+	poolTargets := []string{}
+	for name := range s.pools {
+		moveTargets[poolPrefix+name] = true
+		poolTargets = append(poolTargets, poolPrefix+name)
+	}
+	sort.Strings(poolTargets)
+	moveTargets_sorted = append(poolTargets, moveTargets_sorted...)
+
 	s.moveTargets_sorted = moveTargets_sorted
 	s.moveTargets = moveTargets
 }
 
+// diskStatsFor returns stats of the disk holding path, nil if unknown.
+func diskStatsFor(stats []DiskStats, path string) *DiskStats {
+	var best *DiskStats
+	for i := range stats {
+		ds := &stats[i]
+		if !isUnder(path, ds.Path) {
+			continue
+		}
+		if best == nil || len(ds.Path) > len(best.Path) {
+			best = ds
+		}
+	}
+	return best
+}
+
 func (s *MoveServer) setDiskStats(nds []DiskStats) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	diskStats := []DiskStats{}
-	for _, ds := range nds {
-		for moveTarget := range s.moveTargets {
-			if strings.HasPrefix(moveTarget, ds.Path) {
-				diskStats = append(diskStats, ds)
-				break
-			}
-		}
-	}
-	s.diskStats = diskStats
+	sort.Slice(nds, func(i, j int) bool { return nds[i].Path < nds[j].Path })
+	s.recordDiskSamplesLocked(nds, time.Now())
+	s.diskStats = nds
 }
@@ -0,0 +1,262 @@
+package moveserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Move targets starting with the prefix address a pool, e.g. "pool:movies".
+const poolPrefix = "pool:"
+
+// PoolPolicy selects the directory of a pool a path is moved to.
+type PoolPolicy string
+
+const (
+	// PoolMostFree picks the target on the disk with the most free space.
+	PoolMostFree PoolPolicy = "most_free"
+
+	// PoolFillFirst picks the first target, in configured order, the path
+	// fits on.
+	PoolFillFirst PoolPolicy = "fill_first"
+
+	// PoolKeepTogether moves episodes into the directory of their show if
+	// one of the targets has it and its disk fits them, so all seasons end
+	// up on the same disk. Otherwise it works as PoolMostFree.
+	PoolKeepTogether PoolPolicy = "keep_together"
+)
+
+// PoolConfig configures a pool of target directories, usually on different
+// disks. The targets must have the same import mode.
+type PoolConfig struct {
+	Targets []string `json:"targets"`
+
+	// One of "most_free", "fill_first" or "keep_together", defaults to
+	// "most_free".
+	Policy string `json:"policy"`
+}
+
+type targetPool struct {
+	Name    string
+	Targets []string
+	Policy  PoolPolicy
+}
+
+// parsePools parses the pool configs. Targets of a pool must have the same
+// import mode, as returned by modeFor, because the mode of a move is decided
+// before the pool picks one of them.
+func parsePools(c map[string]PoolConfig, modeFor func(target string) ImportMode) (map[string]*targetPool, error) {
+	pools := map[string]*targetPool{}
+	for name, pc := range c {
+		if len(pc.Targets) == 0 {
+			return nil, fmt.Errorf("Pool %s has no targets", name)
+		}
+		policy := PoolPolicy(pc.Policy)
+		switch policy {
+		case "":
+			policy = PoolMostFree
+		case PoolMostFree, PoolFillFirst, PoolKeepTogether:
+		default:
+			return nil, fmt.Errorf("Pool %s: unknown policy %q", name, pc.Policy)
+		}
+		pool := &targetPool{Name: name, Policy: policy}
+		for _, t := range pc.Targets {
+			pool.Targets = append(pool.Targets, filepath.Clean(t))
+		}
+		mode := modeFor(pool.Targets[0])
+		for _, t := range pool.Targets[1:] {
+			if m := modeFor(t); m != mode {
+				return nil, fmt.Errorf("Pool %s: targets %s and %s have different import modes %s and %s",
+					name, pool.Targets[0], t, mode, m)
+			}
+		}
+		pools[name] = pool
+	}
+	return pools, nil
+}
+
+// poolFor returns the pool addressed by a move target, nil if it is a plain
+// directory.
+func (s *MoveServer) poolFor(moveTo string) (*targetPool, error) {
+	if !strings.HasPrefix(moveTo, poolPrefix) {
+		return nil, nil
+	}
+	pool, ok := s.pools[strings.TrimPrefix(moveTo, poolPrefix)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown pool %s", moveTo)
+	}
+	return pool, nil
+}
+
+// fitsDisk returns an error if size bytes do not fit on the disk or would
+// fill it above its threshold.
+func fitsDisk(ds *DiskStats, name string, size int64) error {
+	if size > ds.Avail {
+		return fmt.Errorf("Not enough space on %s for %s: need %d bytes, %d available",
+			ds.Path, name, size, ds.Avail)
+	}
+	if ds.Threshold > 0 {
+		if p := percent(ds.Used+size, ds.Avail-size); p > ds.Threshold {
+			return fmt.Errorf("Moving %s to %s would fill it to %d%%, above the %d%% threshold",
+				name, ds.Path, p, ds.Threshold)
+		}
+	}
+	return nil
+}
+
+// resolve picks the directory of the pool for a path of the given size. Only
+// targets the path fits on are considered, targets without disk stats are
+// used when no other target fits. showDir is the result of pool.showDir, the
+// path goes there if it fits.
+func (pool *targetPool) resolve(stats []DiskStats, name string, size int64, showDir string) (string, error) {
+	if showDir != "" {
+		if ds := diskStatsFor(stats, showDir); ds == nil || fitsDisk(ds, name, size) == nil {
+			return showDir, nil
+		}
+	}
+
+	var best string
+	var bestStats *DiskStats
+	var unknown string
+	var errs []string
+	for _, target := range pool.Targets {
+		ds := diskStatsFor(stats, target)
+		if ds == nil {
+			if unknown == "" {
+				unknown = target
+			}
+			continue
+		}
+		if err := fitsDisk(ds, name, size); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if pool.Policy == PoolFillFirst {
+			return target, nil
+		}
+		if bestStats == nil || ds.Avail > bestStats.Avail {
+			best, bestStats = target, ds
+		}
+	}
+	if best != "" {
+		return best, nil
+	}
+	if unknown != "" {
+		return unknown, nil
+	}
+	return "", fmt.Errorf("No target of pool %s fits %s: %s", pool.Name, name, strings.Join(errs, "; "))
+}
+
+var showNameRegexp = regexp.MustCompile(`^(.+?)[ ._-]+(?:[Ss]\d{1,2}|\d{1,2}x\d{2})`)
+
+// normalizeShowName makes names like "The.Show" and "The Show" compare equal.
+func normalizeShowName(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	}), " ")
+}
+
+// showDir returns the directory of the show the path belongs to, empty if
+// the pool does not keep shows together, the name is not an episode or no
+// target has the show. It reads the targets, so call it without the lock.
+func (pool *targetPool) showDir(name string) string {
+	if pool.Policy != PoolKeepTogether {
+		return ""
+	}
+	m := showNameRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return ""
+	}
+	show := normalizeShowName(m[1])
+	for _, target := range pool.Targets {
+		fis, err := ioutil.ReadDir(target)
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			if fi.IsDir() && normalizeShowName(fi.Name()) == show {
+				return filepath.Join(target, fi.Name())
+			}
+		}
+	}
+	return ""
+}
+
+// itemSize returns the size of the path or torrent.
+func itemSize(pi *PathInfo) (int64, error) {
+	if pi.Torrent != nil {
+		return pi.Torrent.SizeWhenDone, nil
+	}
+	return pathSize(pi.Path)
+}
+
+// moveEstimate is what a move needs to know about an item that takes file
+// system reads to find out. It is computed without holding the lock.
+type moveEstimate struct {
+	path    string // Path the estimate was made for.
+	size    int64
+	sizeErr error
+	showDir string // Show directory for keep_together pools.
+}
+
+// estimateMove computes the move estimate of a copy of the item. It walks the
+// path, so call it without the lock.
+func (s *MoveServer) estimateMove(pi *PathInfo) *moveEstimate {
+	e := &moveEstimate{path: pi.Path}
+	e.size, e.sizeErr = itemSize(pi)
+	if pool, _ := s.poolFor(pi.MoveTo); pool != nil {
+		e.showDir = pool.showDir(pi.Name)
+	}
+	return e
+}
+
+// resolveMoveTargetLocked returns the directory the path is moved to. Pools
+// are resolved using the current disk stats.
+func (s *MoveServer) resolveMoveTargetLocked(pi *PathInfo, est *moveEstimate) (string, error) {
+	pool, err := s.poolFor(pi.MoveTo)
+	if err != nil {
+		return "", err
+	}
+	if pool == nil {
+		if err := s.validateMoveTargetPath(pi.MoveTo); err != nil {
+			return "", err
+		}
+		return pi.MoveTo, nil
+	}
+	if est.sizeErr != nil {
+		return "", fmt.Errorf("Getting size of %s failed: %v", pi.Path, est.sizeErr)
+	}
+	target, err := pool.resolve(s.diskStats, pi.Name, est.size, est.showDir)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// targetDiskStats returns stats of the disk a torrent will be moved to, nil
+// if unknown. It fails if the torrent fits on no target of its pool. Call it
+// without the lock, pools do not change.
+func (s *MoveServer) targetDiskStats(stats []DiskStats, pi *PathInfo) (*DiskStats, error) {
+	pool, err := s.poolFor(pi.MoveTo)
+	if err != nil {
+		return nil, err
+	}
+	if pool == nil {
+		return diskStatsFor(stats, pi.MoveTo), nil
+	}
+	var size int64
+	if pi.Torrent != nil {
+		size = pi.Torrent.SizeWhenDone
+	}
+	target, err := pool.resolve(stats, pi.Name, size, pool.showDir(pi.Name))
+	if err != nil {
+		return nil, err
+	}
+	return diskStatsFor(stats, target), nil
+}
@@ -0,0 +1,28 @@
+package moveserver
+
+import "testing"
+
+func TestParsePoolsImportModes(t *testing.T) {
+	modes := map[string]ImportMode{
+		"/mnt/a":        ImportModeHardlink,
+		"/mnt/b":        ImportModeHardlink,
+		"/mnt/b/copies": ImportModeCopy,
+	}
+	modeFor := func(target string) ImportMode {
+		return targetImportMode(modes, ImportModeMove, target)
+	}
+	for _, tc := range []struct {
+		targets []string
+		ok      bool
+	}{
+		{[]string{"/mnt/a/movies", "/mnt/b/movies/"}, true},
+		{[]string{"/mnt/c/movies", "/mnt/d/movies"}, true},
+		{[]string{"/mnt/a/movies", "/mnt/b/copies/movies"}, false},
+		{[]string{"/mnt/a/movies", "/mnt/c/movies"}, false},
+	} {
+		_, err := parsePools(map[string]PoolConfig{"movies": {Targets: tc.targets}}, modeFor)
+		if (err == nil) != tc.ok {
+			t.Errorf("parsePools(%v) error = %v, want ok %v", tc.targets, err, tc.ok)
+		}
+	}
+}
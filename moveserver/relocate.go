@@ -0,0 +1,70 @@
+package moveserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	tr "github.com/HawkMachine/transmission_go_api"
+)
+
+const (
+	relocatePollInterval = 5 * time.Second
+	relocateTimeout      = 6 * time.Hour
+)
+
+func findTorrent(torrents []*tr.Torrent, t *tr.Torrent) *tr.Torrent {
+	for _, c := range torrents {
+		if c.HashString == t.HashString {
+			return c
+		}
+	}
+	return nil
+}
+
+// relocateTorrent asks Transmission to move the torrent data to the request
+// target with torrent-set-location and polls the torrent until the data is in
+// the new location.
+func relocateTorrent(s *MoveServer, req MoveRequest) error {
+	location := filepath.Dir(req.To)
+	s.setPathMoveStatus(req.Path, "Requesting torrent-set-location")
+	if err := s.t.SetLocation([]*tr.Torrent{req.Torrent}, location, true); err != nil {
+		return fmt.Errorf("torrent-set-location failed: %v", err)
+	}
+	s.Log("Relocate", fmt.Sprintf("Transmission relocating %s to %s", req.Torrent.Name, location))
+
+	deadline := time.Now().Add(relocateTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(relocatePollInterval)
+
+		torrents, err := s.t.ListAll()
+		if err != nil {
+			s.setPathMoveStatus(req.Path, fmt.Sprintf("Polling Transmission failed: %v", err))
+			continue
+		}
+		t := findTorrent(torrents, req.Torrent)
+		if t == nil {
+			return fmt.Errorf("torrent %s disappeared from Transmission while relocating", req.Torrent.Name)
+		}
+		if t.Error != 0 {
+			return fmt.Errorf("Transmission error while relocating: %s", t.ErrorString)
+		}
+		if filepath.Clean(t.DownloadDir) != location {
+			s.setPathMoveStatus(req.Path, fmt.Sprintf("Waiting, download dir is still %s", t.DownloadDir))
+			continue
+		}
+		// Transmission updates the download dir once the data is moved, the
+		// checks on disk are a safety net.
+		if _, err := os.Stat(req.To); err != nil {
+			s.setPathMoveStatus(req.Path, fmt.Sprintf("Waiting for %s to appear", req.To))
+			continue
+		}
+		if _, err := os.Stat(req.Path); err == nil {
+			s.setPathMoveStatus(req.Path, fmt.Sprintf("Waiting for %s to disappear", req.Path))
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("Transmission did not relocate %s within %v", req.Torrent.Name, relocateTimeout)
+}
@@ -0,0 +1,126 @@
+package moveserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScheduleWindow is a weekly time window with its own Assistant limits.
+type ScheduleWindow struct {
+	Name string `json:"name"`
+
+	// Days of the week the window starts on, "mon", "tue", ... Empty means
+	// every day.
+	Days []string `json:"days"`
+
+	// Start and end of the window as "15:04". The window spans midnight if
+	// the end is before the start.
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	// Overrides max concurrent downloading if set.
+	MaxConcurrentDownloading *int `json:"max_concurrent_downloading"`
+
+	// Turn on Transmission alternative speed limits during the window.
+	AltSpeed bool `json:"alt_speed"`
+
+	// Do not start moves during the window.
+	DisallowMoves bool `json:"disallow_moves"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// scheduleWindow is parsed ScheduleWindow.
+type scheduleWindow struct {
+	ScheduleWindow
+
+	days       map[time.Weekday]bool // nil means every day
+	start, end time.Duration         // since midnight
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Wrong time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseScheduleWindow(w ScheduleWindow) (*scheduleWindow, error) {
+	sw := &scheduleWindow{ScheduleWindow: w}
+	var err error
+	if sw.start, err = parseClock(w.Start); err != nil {
+		return nil, err
+	}
+	if sw.end, err = parseClock(w.End); err != nil {
+		return nil, err
+	}
+	if len(w.Days) > 0 {
+		sw.days = map[time.Weekday]bool{}
+		for _, d := range w.Days {
+			day := strings.ToLower(d)
+			if len(day) > 3 {
+				day = day[:3]
+			}
+			wd, ok := weekdays[day]
+			if !ok {
+				return nil, fmt.Errorf("Wrong day %q", d)
+			}
+			sw.days[wd] = true
+		}
+	}
+	return sw, nil
+}
+
+func (sw *scheduleWindow) startsOn(d time.Weekday) bool {
+	return sw.days == nil || sw.days[d]
+}
+
+// contains returns true if t is inside the window.
+func (sw *scheduleWindow) contains(t time.Time) bool {
+	// Wall clock time, elapsed time since midnight is off by an hour on DST
+	// transition days.
+	sinceMidnight := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second +
+		time.Duration(t.Nanosecond())
+	if sw.start <= sw.end {
+		return sw.startsOn(t.Weekday()) && sinceMidnight >= sw.start && sinceMidnight < sw.end
+	}
+	// Spans midnight, the part after midnight belongs to the previous day.
+	if sinceMidnight >= sw.start {
+		return sw.startsOn(t.Weekday())
+	}
+	return sinceMidnight < sw.end && sw.startsOn((t.Weekday()+6)%7)
+}
+
+func parseSchedule(ws []ScheduleWindow) ([]*scheduleWindow, error) {
+	var r []*scheduleWindow
+	for i, w := range ws {
+		sw, err := parseScheduleWindow(w)
+		if err != nil {
+			return nil, fmt.Errorf("Schedule window %d (%s): %v", i, w.Name, err)
+		}
+		r = append(r, sw)
+	}
+	return r, nil
+}
+
+// activeWindow returns the first window containing t or nil.
+func activeWindow(ws []*scheduleWindow, t time.Time) *scheduleWindow {
+	for _, w := range ws {
+		if w.contains(t) {
+			return w
+		}
+	}
+	return nil
+}
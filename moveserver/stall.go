@@ -0,0 +1,242 @@
+package moveserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tr "github.com/HawkMachine/transmission_go_api"
+)
+
+// Remedies for stalled torrents.
+const (
+	RemedyReannounce   = "reannounce"
+	RemedyVerify       = "verify"
+	RemedyDeprioritize = "deprioritize" // Stop the torrent and start the next one.
+)
+
+// StallConfig configures detection of stalled torrents.
+type StallConfig struct {
+	// Seconds without download progress before a torrent is stalled. Stall
+	// detection is disabled if zero.
+	Timeout int `json:"timeout"`
+
+	// Seconds without connected peers before a torrent is stalled. Defaults
+	// to two days.
+	NoPeersTimeout int `json:"no_peers_timeout"`
+
+	// Remedies tried in order, one per Timeout while the torrent makes no
+	// progress.
+	Remedies []string `json:"remedies"`
+
+	// Number of remedies after which the Assistant gives up, stops the torrent
+	// and flags it for a human. Defaults to the number of remedies.
+	GiveUpAfter int `json:"give_up_after"`
+}
+
+// torrentHealth tracks progress of a downloading torrent over time.
+type torrentHealth struct {
+	lastDownloaded int64
+	lastProgress   time.Time // Last time the torrent downloaded something.
+	lastPeers      time.Time // Last time the torrent had connected peers.
+
+	remedies   int // Remedies tried since the last progress.
+	lastRemedy time.Time
+
+	// Set when the Assistant gave up, cleared by a human.
+	attention string
+}
+
+func parseStallConfig(c StallConfig) (StallConfig, error) {
+	if c.NoPeersTimeout <= 0 {
+		c.NoPeersTimeout = 2 * 24 * 60 * 60
+	}
+	if c.Remedies == nil {
+		c.Remedies = []string{RemedyReannounce, RemedyVerify, RemedyDeprioritize}
+	}
+	for _, r := range c.Remedies {
+		switch r {
+		case RemedyReannounce, RemedyVerify, RemedyDeprioritize:
+		default:
+			return c, fmt.Errorf("Unknown stall remedy %q", r)
+		}
+	}
+	if c.GiveUpAfter <= 0 {
+		c.GiveUpAfter = len(c.Remedies)
+	}
+	return c, nil
+}
+
+func isDownloading(t *tr.Torrent) bool {
+	return t != nil && t.Status != tr.TR_STATUS_PAUSED && t.PercentDone < 1.0
+}
+
+// observe updates the health of downloading torrents in the snapshot and
+// stores it in the snapshot. The Assistant keeps the updated health only if
+// keep is set, so a simulated run does not change it.
+func (a *Assistant) observe(snap *assistSnapshot, now time.Time, keep bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	health := map[string]*torrentHealth{}
+	for name, h := range a.health {
+		if _, ok := snap.pathInfo[name]; ok {
+			c := *h
+			health[name] = &c
+		}
+	}
+	for name, pi := range snap.pathInfo {
+		h, ok := health[name]
+		if !isDownloading(pi.Torrent) {
+			// Time spent paused does not count as a stall.
+			if ok {
+				h.lastProgress = now
+				h.lastPeers = now
+			}
+			continue
+		}
+		if !ok {
+			h = &torrentHealth{lastProgress: now, lastPeers: now, lastDownloaded: pi.Torrent.DownloadedEver}
+			health[name] = h
+		}
+		if pi.Torrent.DownloadedEver > h.lastDownloaded {
+			h.lastDownloaded = pi.Torrent.DownloadedEver
+			h.lastProgress = now
+			h.remedies = 0
+		}
+		if pi.Torrent.PeersConnected > 0 {
+			h.lastPeers = now
+		}
+	}
+	snap.health = health
+	if keep {
+		a.health = health
+	}
+}
+
+// stallReasonLocked returns why the torrent is stalled according to health or
+// an empty string, a.lock has to be held.
+func (a *Assistant) stallReasonLocked(health map[string]*torrentHealth, pi *PathInfo, now time.Time) string {
+	if a.stall.Timeout <= 0 || !isDownloading(pi.Torrent) {
+		return ""
+	}
+	h, ok := health[pi.Name]
+	if !ok {
+		return ""
+	}
+	if pi.Torrent.Error != 0 {
+		return fmt.Sprintf("Transmission error: %s", pi.Torrent.ErrorString)
+	}
+	if d := now.Sub(h.lastProgress); d > time.Duration(a.stall.Timeout)*time.Second {
+		return fmt.Sprintf("No progress for %v at %.1f%%", d.Truncate(time.Minute), pi.Torrent.PercentDone*100)
+	}
+	if d := now.Sub(h.lastPeers); d > time.Duration(a.stall.NoPeersTimeout)*time.Second {
+		return fmt.Sprintf("No peers for %v", d.Truncate(time.Minute))
+	}
+	return ""
+}
+
+// stallRemedy is a remedy decided for a stalled torrent.
+type stallRemedy struct {
+	pi     *PathInfo // Snapshot copy.
+	remedy string    // Empty when giving up.
+	reason string
+}
+
+// planRemedies decides remedies for stalled torrents in the snapshot. It
+// returns the number of download slots the remedies free.
+func (a *Assistant) planRemedies(run *AssistantRun, snap *assistSnapshot) int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	freed := 0
+	for _, pi := range snap.pathInfo {
+		reason := a.stallReasonLocked(snap.health, pi, run.T)
+		if reason == "" {
+			continue
+		}
+		ts := run.TorrentStatus[pi.Name]
+		ts.Health = reason
+		h := snap.health[pi.Name]
+		if h.attention != "" {
+			continue
+		}
+		if run.T.Sub(h.lastRemedy) < time.Duration(a.stall.Timeout)*time.Second {
+			ts.Health += ", waiting for the last remedy"
+			continue
+		}
+		r := &stallRemedy{pi: pi, reason: reason}
+		if h.remedies < a.stall.GiveUpAfter && len(a.stall.Remedies) > 0 {
+			r.remedy = a.stall.Remedies[h.remedies%len(a.stall.Remedies)]
+			run.decide("%s is stalled (%s), %s", pi.Name, reason, r.remedy)
+		} else {
+			run.decide("%s is stalled (%s), giving up after %d remedies", pi.Name, reason, h.remedies)
+		}
+		if r.remedy == "" || r.remedy == RemedyDeprioritize {
+			freed++
+		}
+		run.remedies = append(run.remedies, r)
+	}
+	return freed
+}
+
+// applyRemedy carries out the remedy. It talks to Transmission so msv.lock
+// must not be held.
+func (a *Assistant) applyRemedy(ctx context.Context, r *stallRemedy) error {
+	ts := []*tr.Torrent{r.pi.Torrent}
+	err := callWithContext(ctx, func() error {
+		switch r.remedy {
+		case RemedyReannounce:
+			return a.msv.t.ReannounceTorrents(ts)
+		case RemedyVerify:
+			return a.msv.t.VerifyTorrents(ts)
+		default:
+			// Deprioritizing and giving up both stop the torrent so it does
+			// not use a download slot.
+			return a.msv.t.StopTorrents(ts)
+		}
+	})
+	if err == nil && r.remedy == RemedyDeprioritize {
+		err = a.msv.SetPriority(r.pi.Name, r.pi.Priority-1)
+	}
+
+	action := fmt.Sprintf("Stall remedy %s", r.remedy)
+	if r.remedy == "" {
+		action = "Gave up on stalled torrent, needs a human"
+	}
+	a.recordAction(r.pi.Name, action, err)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if h, ok := a.health[r.pi.Name]; ok {
+		h.lastRemedy = time.Now()
+		h.remedies++
+		if r.remedy == "" {
+			h.attention = r.reason
+		}
+	}
+	return err
+}
+
+// NeedsAttention returns items the Assistant gave up on with the reason.
+func (a *Assistant) NeedsAttention() map[string]string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	r := map[string]string{}
+	for name, h := range a.health {
+		if h.attention != "" {
+			r[name] = h.attention
+		}
+	}
+	return r
+}
+
+// ClearAttention resets the health of the item after a human looked at it.
+func (a *Assistant) ClearAttention(name string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	delete(a.health, name)
+}
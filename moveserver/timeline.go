@@ -0,0 +1,131 @@
+package moveserver
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// Max events kept for a single item.
+	maxItemEvents = 100
+
+	// Max items with a timeline. Timelines of items not updated for the
+	// longest time are dropped first.
+	maxItemTimelines = 500
+)
+
+// ItemEvent is a single entry of the Assistant timeline of an item. Runs
+// making the same decision are folded into one event.
+type ItemEvent struct {
+	First  time.Time // First run with this decision.
+	Last   time.Time // Last run with this decision.
+	Count  int       // Number of runs folded into this event.
+	DryRun bool
+
+	Status      string
+	MoveStatus  string
+	StartStatus string
+	Reason      string // Why the item was not started or moved.
+
+	Action string // Action taken by the Assistant, if any.
+	Err    string // Error of the action, if any.
+}
+
+func (e *ItemEvent) sameDecision(o *ItemEvent) bool {
+	return e.Action == "" && o.Action == "" && e.DryRun == o.DryRun &&
+		e.Status == o.Status && e.MoveStatus == o.MoveStatus &&
+		e.StartStatus == o.StartStatus && e.Reason == o.Reason
+}
+
+type itemTimeline struct {
+	events  []*ItemEvent // Oldest first.
+	updated time.Time
+}
+
+// ItemTimelineInfo summarizes a timeline of an item.
+type ItemTimelineInfo struct {
+	Name    string
+	Updated time.Time
+	Events  int
+}
+
+// addItemEventLocked appends the event to the item timeline, a.lock has to be
+// held.
+func (a *Assistant) addItemEventLocked(name string, e *ItemEvent) {
+	tl, ok := a.timelines[name]
+	if !ok {
+		if len(a.timelines) >= maxItemTimelines {
+			a.dropOldestTimelineLocked()
+		}
+		tl = &itemTimeline{}
+		a.timelines[name] = tl
+	}
+	tl.updated = e.Last
+
+	if n := len(tl.events); n > 0 && tl.events[n-1].sameDecision(e) {
+		tl.events[n-1].Last = e.Last
+		tl.events[n-1].Count++
+		return
+	}
+	tl.events = append(tl.events, e)
+	if len(tl.events) > maxItemEvents {
+		tl.events = tl.events[len(tl.events)-maxItemEvents:]
+	}
+}
+
+func (a *Assistant) dropOldestTimelineLocked() {
+	oldest := ""
+	for name, tl := range a.timelines {
+		if oldest == "" || tl.updated.Before(a.timelines[oldest].updated) {
+			oldest = name
+		}
+	}
+	delete(a.timelines, oldest)
+}
+
+// recordAction adds an action taken for the item to its timeline.
+func (a *Assistant) recordAction(name, action string, err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := time.Now()
+	e := &ItemEvent{First: now, Last: now, Count: 1, Action: action}
+	if err != nil {
+		e.Err = err.Error()
+	}
+	a.addItemEventLocked(name, e)
+}
+
+// Timeline returns the Assistant timeline of the item, newest first. Only
+// events with Last at or after since are returned.
+func (a *Assistant) Timeline(name string, since time.Time) []*ItemEvent {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	tl, ok := a.timelines[name]
+	if !ok {
+		return nil
+	}
+	var r []*ItemEvent
+	for i := len(tl.events) - 1; i >= 0; i-- {
+		if tl.events[i].Last.Before(since) {
+			break
+		}
+		e := *tl.events[i]
+		r = append(r, &e)
+	}
+	return r
+}
+
+// Timelines returns the items with a timeline, most recently updated first.
+func (a *Assistant) Timelines() []ItemTimelineInfo {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var r []ItemTimelineInfo
+	for name, tl := range a.timelines {
+		r = append(r, ItemTimelineInfo{Name: name, Updated: tl.updated, Events: len(tl.events)})
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Updated.After(r[j].Updated) })
+	return r
+}
@@ -0,0 +1,47 @@
+package moveserver
+
+import (
+	"context"
+
+	tr "github.com/HawkMachine/transmission_go_api"
+)
+
+// transmission is the part of the Transmission API used by the MoveServer.
+//
+// The transmission_go_api version is not pinned in this tree, there is no
+// module file or vendored copy. Only ListAll, RemoveTorrents and
+// StartTorrents were used before. The client also has to provide
+// torrent-stop, torrent-set-location, torrent-reannounce, torrent-verify and
+// setting alt-speed-enabled as below, and the Torrent fields HashString,
+// Name, DownloadDir, Error, ErrorString, DownloadedEver, PeersConnected,
+// SizeWhenDone and LeftUntilDone.
+type transmission interface {
+	ListAll() ([]*tr.Torrent, error)
+	RemoveTorrents(ts []*tr.Torrent) error
+	StartTorrents(ts []*tr.Torrent) error
+	StopTorrents(ts []*tr.Torrent) error
+	SetLocation(ts []*tr.Torrent, location string, move bool) error
+	SetAltSpeedEnabled(on bool) error
+	ReannounceTorrents(ts []*tr.Torrent) error
+	VerifyTorrents(ts []*tr.Torrent) error
+}
+
+// The client has to implement the whole interface, a version missing a method
+// fails to build here.
+var _ transmission = (*tr.Transmission)(nil)
+
+// callWithContext runs f and returns its error, or the ctx error if ctx is
+// done first. The Transmission API does not take a context, so a call that
+// hangs is left to finish on its own instead of blocking the caller.
+func callWithContext(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
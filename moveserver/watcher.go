@@ -0,0 +1,154 @@
+package moveserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/HawkMachine/kodi_automation/platform/events"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Delay between the last created path and refreshing torrents, so a batch of
+// paths appearing together results in a single Transmission call.
+const torrentRefreshDelay = 2 * time.Second
+
+// watchSourceDir keeps pathInfo up to date with the source directory between
+// the periodic full rescans. Only direct children of the directory are
+// tracked, same as in updateCache.
+func watchSourceDir(s *MoveServer) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		s.Log("Watcher", fmt.Sprintf("Creating watcher failed, relying on rescans: %v", err))
+		return
+	}
+	defer w.Close()
+	if err := w.Add(s.sourceDir); err != nil {
+		s.Log("Watcher", fmt.Sprintf("Watching %s failed, relying on rescans: %v", s.sourceDir, err))
+		return
+	}
+
+	var refresh <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if s.handleSourceDirEvent(ev) && refresh == nil {
+				refresh = time.After(torrentRefreshDelay)
+			}
+		case <-refresh:
+			refresh = nil
+			go s.refreshTorrents()
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			// Events were lost, only a full rescan brings pathInfo back in sync.
+			if err == fsnotify.ErrEventOverflow {
+				s.Log("Watcher", "Event queue overflowed, rescanning source directory")
+			} else {
+				s.Log("Watcher", fmt.Sprintf("Watch error, rescanning source directory: %v", err))
+			}
+			go s.rescanSourceDir()
+		}
+	}
+}
+
+// handleSourceDirEvent applies a single event to pathInfo. It returns true if
+// a path appeared.
+func (s *MoveServer) handleSourceDirEvent(ev fsnotify.Event) bool {
+	path := filepath.Clean(ev.Name)
+	if filepath.Dir(path) != filepath.Clean(s.sourceDir) {
+		return false
+	}
+	if ev.Has(fsnotify.Create) {
+		s.pathAppeared(path)
+		return true
+	}
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		// A rename may have been followed by a create of the same name.
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			s.pathRemoved(path)
+		}
+	}
+	return false
+}
+
+func (s *MoveServer) pathAppeared(path string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	name := filepath.Base(path)
+	pi, ok := s.pathInfo[name]
+	if !ok {
+		pi = &PathInfo{
+			Name:           name,
+			AllowAssistant: true,
+			MoveTo:         s.defaultMoveTarget,
+			Imported:       s.imported[name],
+		}
+		s.pathInfo[name] = pi
+	}
+	pi.Path = path
+	pi.AllowMove = allowMove(pi)
+	s.publishPath(events.ItemAppeared, pi)
+}
+
+func (s *MoveServer) pathRemoved(path string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	name := filepath.Base(path)
+	pi, ok := s.pathInfo[name]
+	if !ok || pi.Path != path {
+		return
+	}
+	if pi.MoveInfo.Moving {
+		// SetPathMoveResult takes care of paths moved by us.
+		return
+	}
+	if pi.Torrent != nil {
+		// The torrent is still in Transmission, only its data is gone.
+		pi.Path = ""
+		pi.AllowMove = allowMove(pi)
+		return
+	}
+	delete(s.pathInfo, name)
+	delete(s.imported, name)
+	s.pathInfoDisappeared = append(s.pathInfoDisappeared, pi)
+	s.publishPath(events.ItemDisappeared, pi)
+}
+
+// refreshTorrents updates torrents of known paths, so that finished downloads
+// can be moved without waiting for a rescan.
+func (s *MoveServer) refreshTorrents() {
+	torrents, err := s.t.ListAll()
+	if err != nil {
+		s.Log("Watcher", fmt.Sprintf("Getting torrents info error: %v", err))
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, t := range torrents {
+		if pi, ok := s.pathInfo[t.Name]; ok {
+			s.publishTorrentChange(pi.Torrent, t)
+			pi.Torrent = t
+			pi.AllowMove = allowMove(pi)
+		}
+	}
+}
+
+// rescanSourceDir runs the cache update. If one is already running it may
+// have listed the directory before the lost events, so it waits for it to
+// finish and runs another one.
+func (s *MoveServer) rescanSourceDir() {
+	for s.cacheJob.IsRunning() {
+		time.Sleep(time.Second)
+	}
+	s.cacheJob.RunNow()
+}
@@ -1,64 +1,419 @@
 package cron
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"math/rand"
+	"os"
 	"sync"
 	"time"
+
+	"github.com/HawkMachine/kodi_automation/platform/events"
 )
 
 type RunInfo struct {
-	Start    time.Time
-	End      time.Time
-	Duration time.Duration
-	Skipped  bool
-	Err      error
+	Start      time.Time
+	End        time.Time
+	Duration   time.Duration
+	Manual     bool // Started with RunNow.
+	Attempt    int  // Attempt of a scheduled run, 1 for the first one.
+	Skipped    bool
+	SkipReason string
+	TimedOut   bool
+	Abandoned  bool // Timed out and left running, later runs are skipped until it returns.
+	Err        error
+}
+
+// JobRunEvent is the data of events.JobRun events, published when a run
+// starts, ends or is skipped.
+type JobRunEvent struct {
+	Job     string   `json:"job"`
+	Running bool     `json:"running"`
+	Run     *RunInfo `json:"run"`
 }
 
-type CronFunc func() error
+// FailureHook is called when a job reaches the number of consecutive failures
+// it alerts after. It is called once per streak of failures.
+type FailureHook func(job string, failures int, err error)
+
+// CronFunc is run by a cron job. It should return when ctx is done, which
+// happens on timeout or on Cron shutdown.
+type CronFunc func(ctx context.Context) error
 
 type CronJob struct {
 	Name     string
 	f        CronFunc
-	Interval time.Duration
-	Enabled  bool
-	Info     *RunInfo
-	History  []*RunInfo
+	Schedule Schedule
+	Jitter   time.Duration
+	Timeout  time.Duration
+	Retry    RetryPolicy
+	// Consecutive failures after which the failure hook is called.
+	AlertAfter int
+	NextRun    time.Time
+	Enabled    bool
+	Info       *RunInfo
+
+	history *runHistory
+
+	// Directory the history is persisted to, empty if not persisted.
+	historyDir string
+	saveLock   sync.Mutex
+
+	// Guards against overlapping runs.
+	running bool
+
+	// Abandoned runs whose function has not returned yet.
+	abandoned int
+
+	// Attempt of the next scheduled run.
+	attempt int
+
+	consecutiveFailures int
+	failed              FailureHook
+	publish             func(t events.Type, data interface{})
+
+	// Wakes the run loop after the schedule changed.
+	wake chan struct{}
+
+	// Context of the Cron the job is registered with. Runs are added to the
+	// in-flight runs waited for on shutdown with track, which fails after
+	// shutdown, and removed with untrack.
+	ctx     context.Context
+	track   func() bool
+	untrack func()
 
 	lock sync.Mutex
 }
 
-func (cj *CronJob) newRun() bool {
+// Options are optional settings of a cron job.
+type Options struct {
+	// Each run is delayed by a random duration up to Jitter.
+	Jitter time.Duration
+
+	// Context of each run is canceled after Timeout, zero means no timeout.
+	Timeout time.Duration
+
+	// Number of runs kept in the history, defaults to 1000.
+	HistorySize int
+
+	// Retries of failed scheduled runs, no retries by default.
+	Retry RetryPolicy
+
+	// Consecutive failures after which the failure hook is called, defaults
+	// to 3.
+	AlertAfter int
+
+	// Run the job once right after registration.
+	RunOnStart bool
+}
+
+const defaultAlertAfter = 3
+
+// RetryPolicy retries failed scheduled runs with exponential backoff before
+// the job falls back to its schedule.
+type RetryPolicy struct {
+	// Attempts of a scheduled run including the first one.
+	MaxAttempts int
+
+	// Delay before the first retry, doubled for each next one.
+	Backoff time.Duration
+
+	// Upper bound of the delay, zero means no bound.
+	MaxBackoff time.Duration
+}
+
+// delay returns the delay before the retry following the given attempt.
+func (rp RetryPolicy) delay(attempt int) time.Duration {
+	d := rp.Backoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if rp.MaxBackoff > 0 && d >= rp.MaxBackoff {
+			break
+		}
+	}
+	if rp.MaxBackoff > 0 && d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+	return d
+}
+
+// scheduleNext computes and records the next run after t.
+func (cj *CronJob) scheduleNext(t time.Time) time.Time {
 	cj.lock.Lock()
 	defer cj.lock.Unlock()
 
-	if !cj.Enabled {
-		cj.History = append(cj.History, &RunInfo{Start: time.Now(), Skipped: true})
-		return false
+	next := cj.Schedule.Next(t)
+	if !next.IsZero() && cj.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(cj.Jitter))))
 	}
-	cj.Info = &RunInfo{Start: time.Now()}
-	return true
+	cj.NextRun = next
+	return next
 }
 
-func (cj *CronJob) runEnded(err error) {
+// retryAt schedules a retry of a failed run at t.
+func (cj *CronJob) retryAt(t time.Time) {
 	cj.lock.Lock()
 	defer cj.lock.Unlock()
 
+	cj.NextRun = t
+}
+
+// GetNextRun returns the time of the next scheduled run.
+func (cj *CronJob) GetNextRun() time.Time {
+	cj.lock.Lock()
+	defer cj.lock.Unlock()
+
+	return cj.NextRun
+}
+
+// newRun starts a new run. Scheduled runs are skipped if the job is disabled,
+// any run is skipped if the previous one is still running.
+func (cj *CronJob) newRun(manual bool) bool {
+	cj.lock.Lock()
+	skipReason := ""
+	if cj.ctx.Err() != nil {
+		skipReason = "Cron is shut down"
+	} else if cj.abandoned > 0 {
+		skipReason = "Timed out run still running"
+	} else if cj.running {
+		skipReason = "Previous run still running"
+	} else if !manual && !cj.Enabled {
+		skipReason = "Disabled"
+	}
+	if skipReason != "" {
+		ri := &RunInfo{
+			Start:      time.Now(),
+			Manual:     manual,
+			Skipped:    true,
+			SkipReason: skipReason,
+		}
+		cj.history.add(ri)
+		cj.lock.Unlock()
+		cj.saveHistory()
+		cj.publishRun(ri, false)
+		return false
+	}
+	cj.running = true
+	cj.Info = &RunInfo{Start: time.Now(), Manual: manual}
+	if !manual {
+		cj.Info.Attempt = cj.attempt
+	}
+	ri := *cj.Info
+	cj.lock.Unlock()
+	cj.publishRun(&ri, true)
+	return true
+}
+
+// publishRun publishes a copy of the run, which is not changed afterwards.
+func (cj *CronJob) publishRun(ri *RunInfo, running bool) {
+	if cj.publish != nil {
+		cj.publish(events.JobRun, JobRunEvent{Job: cj.Name, Running: running, Run: ri})
+	}
+}
+
+// runEnded records the result of the current run.
+func (cj *CronJob) runEnded(err error, timedOut bool) {
+	cj.lock.Lock()
 	cj.Info.End = time.Now()
 	cj.Info.Duration = cj.Info.End.Sub(cj.Info.Start)
 	cj.Info.Err = err
+	cj.Info.TimedOut = timedOut
+	cj.history.add(cj.Info)
+	ri := *cj.Info
+	cj.Info = nil
+	alert := false
+	if err != nil {
+		cj.consecutiveFailures++
+		alert = cj.consecutiveFailures == cj.AlertAfter
+	} else {
+		cj.consecutiveFailures = 0
+	}
+	failures := cj.consecutiveFailures
+	cj.lock.Unlock()
 
-	if len(cj.History) > 1000 {
-		cj.History = cj.History[:1000]
+	cj.saveHistory()
+	cj.publishRun(&ri, false)
+	if alert && cj.failed != nil {
+		cj.failed(cj.Name, failures, err)
 	}
-	cj.History = append([]*RunInfo{cj.Info}, cj.History...)
-	cj.Info = nil
 }
 
+// saveHistory persists the history if the job has a history directory.
+func (cj *CronJob) saveHistory() {
+	if cj.historyDir == "" {
+		return
+	}
+	cj.saveLock.Lock()
+	defer cj.saveLock.Unlock()
+
+	if err := saveHistory(cj.historyDir, cj.Name, cj.History()); err != nil {
+		log.Printf("Saving history of cron job %s failed: %v", cj.Name, err)
+	}
+}
+
+// History returns the recorded runs, newest first.
+func (cj *CronJob) History() []*RunInfo {
+	cj.lock.Lock()
+	defer cj.lock.Unlock()
+
+	return cj.history.list()
+}
+
+// Stats returns statistics of the recorded runs.
+func (cj *CronJob) Stats() Stats {
+	return computeStats(cj.History())
+}
+
+// runFinished is called when the job function returned, also for abandoned
+// runs. Until then new runs are skipped.
+func (cj *CronJob) runFinished() {
+	cj.lock.Lock()
+	defer cj.lock.Unlock()
+
+	cj.running = false
+}
+
+// abandonRun records the current run as timed out and abandoned. The job stays
+// running, next runs are skipped until its function returns.
+func (cj *CronJob) abandonRun(err error) {
+	cj.lock.Lock()
+	cj.Info.Abandoned = true
+	cj.abandoned++
+	cj.lock.Unlock()
+
+	cj.runEnded(err, true)
+}
+
+// abandonedRunReturned is called when the function of an abandoned run
+// returned.
+func (cj *CronJob) abandonedRunReturned(err error) {
+	cj.lock.Lock()
+	cj.abandoned--
+	cj.lock.Unlock()
+	cj.runFinished()
+
+	log.Printf("Abandoned run of cron job %s returned: %v", cj.Name, err)
+}
+
+// AbandonedRuns returns the number of abandoned runs whose function has not
+// returned yet.
+func (cj *CronJob) AbandonedRuns() int {
+	cj.lock.Lock()
+	defer cj.lock.Unlock()
+
+	return cj.abandoned
+}
+
+// execute runs the job function and returns whether it ran and its error.
+// If the run context is done before the function returns, the run is recorded
+// right away and the function is left to finish on its own. A timed out run is
+// abandoned, so it does not block the scheduling of the next runs, which are
+// skipped until the function returns.
+func (cj *CronJob) execute(manual bool) (bool, error) {
+	if !cj.newRun(manual) {
+		return false, nil
+	}
+	if !cj.track() {
+		err := fmt.Errorf("Canceled: Cron is shut down")
+		cj.runEnded(err, false)
+		cj.runFinished()
+		return true, err
+	}
+
+	ctx, cancel := cj.ctx, context.CancelFunc(func() {})
+	if cj.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(cj.ctx, cj.Timeout)
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer cj.untrack()
+		done <- cj.f(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+		cj.runEnded(err, ctx.Err() == context.DeadlineExceeded)
+		cj.runFinished()
+		cancel()
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("Timed out after %v", cj.Timeout)
+			cj.abandonRun(err)
+			go func() {
+				cj.abandonedRunReturned(<-done)
+				cancel()
+			}()
+		} else {
+			err = fmt.Errorf("Canceled: %v", ctx.Err())
+			cj.runEnded(err, false)
+			go func() {
+				<-done
+				cj.runFinished()
+				cancel()
+			}()
+		}
+	}
+	return true, err
+}
+
+// Run makes a scheduled run of the job and schedules the next one, which is a
+// retry if the run failed and the retry policy allows another attempt.
 func (cj *CronJob) Run() {
-	if !cj.newRun() {
+	ran, err := cj.execute(false)
+
+	cj.lock.Lock()
+	retry := ran && err != nil && cj.attempt < cj.Retry.MaxAttempts && cj.ctx.Err() == nil
+	attempt := cj.attempt
+	if retry {
+		cj.attempt++
+	} else {
+		cj.attempt = 1
+	}
+	cj.lock.Unlock()
+
+	if retry {
+		cj.retryAt(time.Now().Add(cj.Retry.delay(attempt)))
 		return
 	}
-	cj.runEnded(cj.f())
+	cj.scheduleNext(time.Now())
+}
+
+// RunNow runs the job right away, even if it is disabled. It returns false if
+// the run was skipped because another run of the job is in progress.
+func (cj *CronJob) RunNow() bool {
+	ran, _ := cj.execute(true)
+	return ran
+}
+
+// IsRunning returns true if a run of the job is in progress.
+func (cj *CronJob) IsRunning() bool {
+	cj.lock.Lock()
+	defer cj.lock.Unlock()
+
+	return cj.running
+}
+
+// SetSchedule changes the schedule of the job.
+func (cj *CronJob) SetSchedule(s Schedule) {
+	cj.lock.Lock()
+	cj.Schedule = s
+	cj.lock.Unlock()
+
+	cj.scheduleNext(time.Now())
+	select {
+	case cj.wake <- struct{}{}:
+	default:
+	}
+}
+
+// GetSchedule returns the schedule of the job.
+func (cj *CronJob) GetSchedule() Schedule {
+	cj.lock.Lock()
+	defer cj.lock.Unlock()
+
+	return cj.Schedule
 }
 
 func (cj *CronJob) Enable() {
@@ -85,12 +440,111 @@ func (cj *CronJob) IsEnabled() bool {
 type Cron struct {
 	jobs map[string]*CronJob
 
+	// Canceled on shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Running job functions.
+	inFlight sync.WaitGroup
+
+	// Directory job histories are persisted to.
+	historyDir string
+
+	failureHook FailureHook
+
+	// Job runs are published on the bus, if set.
+	bus *events.Bus
+
 	lock sync.Mutex
 }
 
 func New() *Cron {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Cron{
-		jobs: map[string]*CronJob{},
+		jobs:   map[string]*CronJob{},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Shutdown stops scheduling jobs, cancels running ones and waits for them to
+// return or for ctx to be done.
+func (c *Cron) Shutdown(ctx context.Context) error {
+	// Under the lock, so no run is added to inFlight once Wait is called.
+	c.lock.Lock()
+	c.cancel()
+	c.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Cron jobs still running: %v", ctx.Err())
+	}
+}
+
+// track adds a run to the in-flight runs. It returns false after shutdown.
+func (c *Cron) track() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.ctx.Err() != nil {
+		return false
+	}
+	c.inFlight.Add(1)
+	return true
+}
+
+// SetHistoryDir makes jobs registered afterwards load their history from dir
+// and save it there after each run.
+func (c *Cron) SetHistoryDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Creating cron history directory failed: %v", err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.historyDir = dir
+	return nil
+}
+
+// SetFailureHook sets the hook called when a job keeps failing.
+func (c *Cron) SetFailureHook(f FailureHook) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.failureHook = f
+}
+
+// SetEventBus sets the bus job runs are published on.
+func (c *Cron) SetEventBus(b *events.Bus) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.bus = b
+}
+
+func (c *Cron) publish(t events.Type, data interface{}) {
+	c.lock.Lock()
+	b := c.bus
+	c.lock.Unlock()
+
+	b.Publish("cron", t, data)
+}
+
+// jobFailed calls the failure hook, if any.
+func (c *Cron) jobFailed(job string, failures int, err error) {
+	c.lock.Lock()
+	f := c.failureHook
+	c.lock.Unlock()
+
+	if f != nil {
+		f(job, failures, err)
 	}
 }
 
@@ -100,28 +554,101 @@ func (c *Cron) CronJobs() map[string]*CronJob {
 	return c.jobs
 }
 
-func (c *Cron) Register(name string, f CronFunc, interval time.Duration) (*CronJob, error) {
+// Job returns the job with the given name.
+func (c *Cron) Job(name string) (*CronJob, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	j, ok := c.jobs[name]
+	if !ok {
+		return nil, fmt.Errorf("Cron job %s not found", name)
+	}
+	return j, nil
+}
+
+// Register registers a job running f on the schedule. Use Every for
+// intervals or Parse for cron expressions.
+func (c *Cron) Register(name string, f CronFunc, schedule Schedule) (*CronJob, error) {
+	return c.RegisterWithOptions(name, f, schedule, Options{})
+}
+
+func (c *Cron) RegisterWithOptions(name string, f CronFunc, schedule Schedule, o Options) (*CronJob, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
 	if _, ok := c.jobs[name]; ok {
 		return nil, fmt.Errorf("Cron job %s already exists!", name)
 	}
+	if schedule == nil {
+		return nil, fmt.Errorf("Cron job %s has no schedule", name)
+	}
+	if c.ctx.Err() != nil {
+		return nil, fmt.Errorf("Cron is shut down")
+	}
 
+	if o.AlertAfter <= 0 {
+		o.AlertAfter = defaultAlertAfter
+	}
 	j := &CronJob{
-		Name:     name,
-		f:        f,
-		Enabled:  true,
-		Interval: interval,
+		Name:       name,
+		f:          f,
+		Enabled:    true,
+		Schedule:   schedule,
+		Jitter:     o.Jitter,
+		Timeout:    o.Timeout,
+		Retry:      o.Retry,
+		AlertAfter: o.AlertAfter,
+		attempt:    1,
+		failed:     c.jobFailed,
+		publish:    c.publish,
+		history:    newRunHistory(o.HistorySize),
+		wake:       make(chan struct{}, 1),
+		ctx:        c.ctx,
+		track:      c.track,
+		untrack:    c.inFlight.Done,
 	}
+	if c.historyDir != "" {
+		j.historyDir = c.historyDir
+		runs, err := loadHistory(c.historyDir, name)
+		if err != nil {
+			log.Printf("Loading history of cron job %s failed: %v", name, err)
+		}
+		for i := len(runs) - 1; i >= 0; i-- {
+			j.history.add(runs[i])
+		}
+		j.consecutiveFailures = computeStats(runs).ConsecutiveFailures
+	}
+	j.scheduleNext(time.Now())
 	c.jobs[name] = j
-	go c.run(j)
+	go c.run(j, o.RunOnStart)
 	return j, nil
 }
 
-func (c *Cron) run(j *CronJob) {
-	for {
-		time.Sleep(j.Interval)
+func (c *Cron) run(j *CronJob, runNow bool) {
+	if runNow {
 		j.Run()
 	}
+	for {
+		// A schedule that never fires waits for a schedule change.
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if next := j.GetNextRun(); !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			fire = timer.C
+		}
+
+		select {
+		case <-fire:
+			j.Run()
+		case <-j.wake:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-c.ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
 }
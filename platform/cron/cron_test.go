@@ -0,0 +1,238 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// never is a schedule that never fires, the tests run jobs with RunNow.
+var never = MustParse("TZ=UTC 0 0 30 2 *")
+
+// waitFor fails the test if cond does not become true soon.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	c := New()
+	defer c.Shutdown(context.Background())
+	j, err := c.RegisterWithOptions("job", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, never, Options{Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("RegisterWithOptions() failed: %v", err)
+	}
+
+	for run := 1; run <= 2; run++ {
+		if !j.RunNow() {
+			t.Fatalf("Run %d was skipped", run)
+		}
+		if h := j.History(); !h[0].TimedOut || h[0].Err == nil {
+			t.Errorf("Run %d = %+v, want timed out with an error", run, h[0])
+		}
+		waitFor(t, "the run to finish", func() bool { return !j.IsRunning() })
+	}
+}
+
+func TestAbandonedRunSkipsNextRuns(t *testing.T) {
+	c := New()
+	defer c.Shutdown(context.Background())
+	release := make(chan struct{})
+	j, err := c.RegisterWithOptions("job", func(ctx context.Context) error {
+		// Ignores ctx.
+		<-release
+		return nil
+	}, never, Options{Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("RegisterWithOptions() failed: %v", err)
+	}
+
+	start := time.Now()
+	if !j.RunNow() {
+		t.Fatal("First run was skipped")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("RunNow() took %v, want it to return on the timeout", d)
+	}
+	if h := j.History(); !h[0].TimedOut || !h[0].Abandoned {
+		t.Errorf("First run = %+v, want timed out and abandoned", h[0])
+	}
+	if !j.IsRunning() || j.AbandonedRuns() != 1 {
+		t.Errorf("IsRunning(), AbandonedRuns() = %v, %d, want true, 1", j.IsRunning(), j.AbandonedRuns())
+	}
+
+	// The function of the abandoned run has not returned, so the next run
+	// must not overlap it.
+	if j.RunNow() {
+		t.Fatal("Second run overlapped the abandoned run")
+	}
+	if h := j.History(); !h[0].Skipped || h[0].SkipReason != "Timed out run still running" {
+		t.Errorf("Second run = %+v, want skipped for the abandoned run", h[0])
+	}
+
+	close(release)
+	waitFor(t, "the abandoned run to return", func() bool {
+		return !j.IsRunning() && j.AbandonedRuns() == 0
+	})
+	if !j.RunNow() {
+		t.Fatal("Run after the abandoned run returned was skipped")
+	}
+	if h := j.History(); h[0].Err != nil || h[0].TimedOut {
+		t.Errorf("Last run = %+v, want a successful run", h[0])
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	c := New()
+	started := make(chan struct{})
+	j, err := c.Register("job", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}, never)
+	if err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+
+	go j.RunNow()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+	waitFor(t, "the run to be recorded", func() bool { return len(j.History()) == 1 })
+	if h := j.History(); h[0].Err == nil || h[0].TimedOut {
+		t.Errorf("Run = %+v, want canceled", h[0])
+	}
+
+	waitFor(t, "the run to finish", func() bool { return !j.IsRunning() })
+	if j.RunNow() {
+		t.Error("Run after Shutdown() was not skipped")
+	}
+	if h := j.History(); h[0].SkipReason != "Cron is shut down" {
+		t.Errorf("Run after Shutdown() = %+v, want skipped", h[0])
+	}
+	if _, err := c.Register("other", func(ctx context.Context) error { return nil }, never); err == nil {
+		t.Error("Register() after Shutdown() succeeded")
+	}
+}
+
+func TestShutdownGivesUpOnDeadline(t *testing.T) {
+	c := New()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	j, err := c.Register("job", func(ctx context.Context) error {
+		// Ignores ctx.
+		close(started)
+		<-release
+		return nil
+	}, never)
+	if err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+
+	go j.RunNow()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(ctx); err == nil {
+		t.Error("Shutdown() succeeded while a job is still running")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for _, tc := range []struct {
+		rp      RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{RetryPolicy{Backoff: time.Second}, 1, time.Second},
+		{RetryPolicy{Backoff: time.Second}, 2, 2 * time.Second},
+		{RetryPolicy{Backoff: time.Second}, 5, 16 * time.Second},
+		{RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}, 3, 4 * time.Second},
+		{RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}, 4, 5 * time.Second},
+		{RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}, 100, 5 * time.Second},
+	} {
+		if got := tc.rp.delay(tc.attempt); got != tc.want {
+			t.Errorf("%+v delay(%d) = %v, want %v", tc.rp, tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestRetriesAndFailureHook(t *testing.T) {
+	c := New()
+	defer c.Shutdown(context.Background())
+
+	var lock sync.Mutex
+	var calls []time.Time
+	var alerts []int
+	c.SetFailureHook(func(job string, failures int, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		alerts = append(alerts, failures)
+		if job != "job" || err == nil {
+			t.Errorf("Failure hook called with %s, %v", job, err)
+		}
+	})
+	count := func() (int, []int) {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(calls), append([]int(nil), alerts...)
+	}
+
+	backoff := 50 * time.Millisecond
+	j, err := c.RegisterWithOptions("job", func(ctx context.Context) error {
+		lock.Lock()
+		defer lock.Unlock()
+		calls = append(calls, time.Now())
+		return fmt.Errorf("Failure %d", len(calls))
+	}, Every(time.Second), Options{
+		Retry:      RetryPolicy{MaxAttempts: 3, Backoff: backoff},
+		AlertAfter: 3,
+	})
+	if err != nil {
+		t.Fatalf("RegisterWithOptions() failed: %v", err)
+	}
+
+	// Attempts 1 to 3 of the first scheduled run.
+	waitFor(t, "the retries", func() bool { n, _ := count(); return n >= 3 })
+	if n, alerts := count(); n != 3 || !reflect.DeepEqual(alerts, []int{3}) {
+		t.Errorf("Calls, alerts after the retries = %d, %v, want 3, [3]", n, alerts)
+	}
+	// The next scheduled run starts with attempt 1 again.
+	waitFor(t, "the next scheduled run", func() bool { n, _ := count(); return n >= 4 })
+
+	lock.Lock()
+	for i, want := range []time.Duration{backoff, 2 * backoff} {
+		if d := calls[i+1].Sub(calls[i]); d < want || d >= time.Second {
+			t.Errorf("Delay before attempt %d = %v, want at least %v", i+2, d, want)
+		}
+	}
+	lock.Unlock()
+
+	var attempts []int
+	for _, ri := range j.History() {
+		attempts = append(attempts, ri.Attempt)
+	}
+	if want := []int{1, 3, 2, 1}; !reflect.DeepEqual(attempts, want) {
+		t.Errorf("Attempts, newest first = %v, want %v", attempts, want)
+	}
+	// The hook is called once per streak of failures.
+	if _, alerts := count(); len(alerts) != 1 {
+		t.Errorf("Failure hook called %d times, want once", len(alerts))
+	}
+}
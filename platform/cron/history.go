@@ -0,0 +1,194 @@
+package cron
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const defaultHistorySize = 1000
+
+// runHistory is a bounded ring buffer of runs.
+type runHistory struct {
+	runs  []*RunInfo
+	start int // Index of the oldest run.
+	n     int
+}
+
+func newRunHistory(size int) *runHistory {
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	return &runHistory{runs: make([]*RunInfo, size)}
+}
+
+// add records a run, dropping the oldest one when full.
+func (h *runHistory) add(ri *RunInfo) {
+	if h.n < len(h.runs) {
+		h.runs[(h.start+h.n)%len(h.runs)] = ri
+		h.n++
+		return
+	}
+	h.runs[h.start] = ri
+	h.start = (h.start + 1) % len(h.runs)
+}
+
+// list returns the runs, newest first.
+func (h *runHistory) list() []*RunInfo {
+	l := make([]*RunInfo, 0, h.n)
+	for i := h.n - 1; i >= 0; i-- {
+		l = append(l, h.runs[(h.start+i)%len(h.runs)])
+	}
+	return l
+}
+
+// runInfoJSON is the stored form of RunInfo, errors are kept as strings.
+type runInfoJSON struct {
+	Start      time.Time     `json:"start"`
+	End        time.Time     `json:"end,omitempty"`
+	Duration   time.Duration `json:"duration,omitempty"`
+	Manual     bool          `json:"manual,omitempty"`
+	Attempt    int           `json:"attempt,omitempty"`
+	Skipped    bool          `json:"skipped,omitempty"`
+	SkipReason string        `json:"skip_reason,omitempty"`
+	TimedOut   bool          `json:"timed_out,omitempty"`
+	Abandoned  bool          `json:"abandoned,omitempty"`
+	Err        string        `json:"err,omitempty"`
+}
+
+func (ri *RunInfo) MarshalJSON() ([]byte, error) {
+	j := runInfoJSON{
+		Start:      ri.Start,
+		End:        ri.End,
+		Duration:   ri.Duration,
+		Manual:     ri.Manual,
+		Attempt:    ri.Attempt,
+		Skipped:    ri.Skipped,
+		SkipReason: ri.SkipReason,
+		TimedOut:   ri.TimedOut,
+		Abandoned:  ri.Abandoned,
+	}
+	if ri.Err != nil {
+		j.Err = ri.Err.Error()
+	}
+	return json.Marshal(j)
+}
+
+func (ri *RunInfo) UnmarshalJSON(b []byte) error {
+	var j runInfoJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*ri = RunInfo{
+		Start:      j.Start,
+		End:        j.End,
+		Duration:   j.Duration,
+		Manual:     j.Manual,
+		Attempt:    j.Attempt,
+		Skipped:    j.Skipped,
+		SkipReason: j.SkipReason,
+		TimedOut:   j.TimedOut,
+		Abandoned:  j.Abandoned,
+	}
+	if j.Err != "" {
+		ri.Err = errors.New(j.Err)
+	}
+	return nil
+}
+
+func historyPath(dir, name string) string {
+	return filepath.Join(dir, name+".json")
+}
+
+// loadHistory reads stored runs, newest first. A missing file is not an error.
+func loadHistory(dir, name string) ([]*RunInfo, error) {
+	b, err := ioutil.ReadFile(historyPath(dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []*RunInfo
+	if err := json.Unmarshal(b, &runs); err != nil {
+		return nil, fmt.Errorf("Parsing history of %s failed: %v", name, err)
+	}
+	return runs, nil
+}
+
+// saveHistory writes runs through a temporary file so a crash does not leave
+// a truncated history behind.
+func saveHistory(dir, name string, runs []*RunInfo) error {
+	b, err := json.Marshal(runs)
+	if err != nil {
+		return err
+	}
+	path := historyPath(dir, name)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Stats summarizes the runs in the history of a job. Skipped runs count
+// neither as successes nor as failures.
+type Stats struct {
+	Runs                int
+	Failures            int
+	Skipped             int
+	SuccessRate         float64 // Percent of runs that succeeded.
+	P50                 time.Duration
+	P95                 time.Duration
+	LastError           error
+	LastErrorTime       time.Time
+	ConsecutiveFailures int
+}
+
+// computeStats computes Stats from runs ordered newest first.
+func computeStats(runs []*RunInfo) Stats {
+	st := Stats{}
+	var durations []time.Duration
+	streak := true
+	for _, ri := range runs {
+		if ri.Skipped {
+			st.Skipped++
+			continue
+		}
+		st.Runs++
+		durations = append(durations, ri.Duration)
+		if ri.Err == nil {
+			streak = false
+			continue
+		}
+		st.Failures++
+		if streak {
+			st.ConsecutiveFailures++
+		}
+		if st.LastError == nil {
+			st.LastError = ri.Err
+			st.LastErrorTime = ri.Start
+		}
+	}
+	if st.Runs > 0 {
+		st.SuccessRate = 100 * float64(st.Runs-st.Failures) / float64(st.Runs)
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		st.P50 = percentile(durations, 50)
+		st.P95 = percentile(durations, 95)
+	}
+	return st
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	idx := (len(sorted)*p+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
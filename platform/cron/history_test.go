@@ -0,0 +1,148 @@
+package cron
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2024, 6, 15, 10, 0, 0, 0, time.UTC)
+
+// run returns a run started i minutes after t0.
+func run(i int, d time.Duration, err error) *RunInfo {
+	start := t0.Add(time.Duration(i) * time.Minute)
+	return &RunInfo{Start: start, End: start.Add(d), Duration: d, Err: err}
+}
+
+// starts returns the start minutes of runs.
+func starts(runs []*RunInfo) []int {
+	var l []int
+	for _, ri := range runs {
+		l = append(l, int(ri.Start.Sub(t0)/time.Minute))
+	}
+	return l
+}
+
+func TestRunHistory(t *testing.T) {
+	h := newRunHistory(3)
+	if got := h.list(); len(got) != 0 {
+		t.Errorf("list() of an empty history = %v, want none", starts(got))
+	}
+	for i, want := range [][]int{
+		{0},
+		{1, 0},
+		{2, 1, 0},
+		// Full, the oldest runs are dropped.
+		{3, 2, 1},
+		{4, 3, 2},
+		{5, 4, 3},
+		{6, 5, 4},
+	} {
+		h.add(run(i, time.Second, nil))
+		if got := starts(h.list()); !reflect.DeepEqual(got, want) {
+			t.Errorf("list() after %d runs = %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestHistoryPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cron")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if runs, err := loadHistory(dir, "job"); err != nil || runs != nil {
+		t.Errorf("loadHistory() without a file = %v, %v, want nothing", runs, err)
+	}
+
+	want := []*RunInfo{
+		{Start: t0.Add(2 * time.Minute), Skipped: true, SkipReason: "Disabled"},
+		{Start: t0.Add(time.Minute), End: t0.Add(time.Minute + time.Second), Duration: time.Second, Manual: true, TimedOut: true, Abandoned: true, Err: errors.New("Timed out after 1s")},
+		{Start: t0, End: t0.Add(3 * time.Second), Duration: 3 * time.Second, Attempt: 2},
+	}
+	if err := saveHistory(dir, "job", want); err != nil {
+		t.Fatalf("saveHistory() failed: %v", err)
+	}
+	got, err := loadHistory(dir, "job")
+	if err != nil {
+		t.Fatalf("loadHistory() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadHistory() = %+v, want %+v", got, want)
+	}
+
+	if err := ioutil.WriteFile(historyPath(dir, "broken"), []byte("[{"), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := loadHistory(dir, "broken"); err == nil {
+		t.Error("loadHistory() of a broken file succeeded")
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	fail := errors.New("failed")
+	wrapped := newRunHistory(4)
+	for i := 0; i < 10; i++ {
+		var err error
+		if i%3 == 0 {
+			err = fail
+		}
+		wrapped.add(run(i, time.Duration(i)*time.Second, err))
+	}
+	var durations []*RunInfo
+	for i := 20; i >= 1; i-- {
+		durations = append(durations, run(i, time.Duration(i)*time.Second, nil))
+	}
+
+	for _, tc := range []struct {
+		name string
+		runs []*RunInfo
+		want Stats
+	}{
+		{"empty", nil, Stats{}},
+		{
+			name: "skipped only",
+			runs: []*RunInfo{{Start: t0, Skipped: true}},
+			want: Stats{Skipped: 1},
+		},
+		{
+			name: "percentiles",
+			runs: durations,
+			want: Stats{Runs: 20, SuccessRate: 100, P50: 10 * time.Second, P95: 19 * time.Second},
+		},
+		{
+			// Newest first: 9 failed, skipped, 8 and 7 succeeded, 6 failed.
+			name: "consecutive failures",
+			runs: []*RunInfo{
+				run(9, time.Second, fail),
+				{Start: t0, Skipped: true},
+				run(8, 2*time.Second, nil),
+				run(7, 3*time.Second, nil),
+				run(6, 4*time.Second, errors.New("older")),
+			},
+			want: Stats{
+				Runs: 4, Failures: 2, Skipped: 1, SuccessRate: 50,
+				P50: 2 * time.Second, P95: 4 * time.Second,
+				LastError: fail, LastErrorTime: t0.Add(9 * time.Minute), ConsecutiveFailures: 1,
+			},
+		},
+		{
+			// Runs 6 to 9 are left, 6 and 9 failed.
+			name: "wrapped history",
+			runs: wrapped.list(),
+			want: Stats{
+				Runs: 4, Failures: 2, SuccessRate: 50,
+				P50: 7 * time.Second, P95: 9 * time.Second,
+				LastError: fail, LastErrorTime: t0.Add(9 * time.Minute), ConsecutiveFailures: 1,
+			},
+		},
+	} {
+		if got := computeStats(tc.runs); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: computeStats() = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
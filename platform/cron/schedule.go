@@ -0,0 +1,268 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule computes when a cron job runs next.
+type Schedule interface {
+	// Next returns the first run time after t.
+	Next(t time.Time) time.Time
+
+	String() string
+}
+
+// intervalSchedule runs a job at multiples of an interval. The runs are
+// aligned to the interval, so a restart does not reset timing.
+type intervalSchedule struct {
+	d time.Duration
+}
+
+// Every returns a schedule running a job every d.
+func Every(d time.Duration) Schedule {
+	if d < time.Second {
+		d = time.Second
+	}
+	return &intervalSchedule{d: d}
+}
+
+func (s *intervalSchedule) Next(t time.Time) time.Time {
+	return t.Truncate(s.d).Add(s.d)
+}
+
+func (s *intervalSchedule) String() string {
+	return fmt.Sprintf("@every %v", s.d)
+}
+
+// exprSchedule is a schedule given by a standard 5 field cron expression.
+type exprSchedule struct {
+	spec string
+	loc  *time.Location
+
+	minute, hour, dom, month, dow uint64 // Bit sets of allowed values.
+	domStar, dowStar              bool
+}
+
+type fieldRange struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteRange = fieldRange{min: 0, max: 59}
+	hourRange   = fieldRange{min: 0, max: 23}
+	domRange    = fieldRange{min: 1, max: 31}
+	monthRange  = fieldRange{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowRange = fieldRange{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var shortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a schedule. Accepted are standard 5 field cron expressions
+// ("*/15 8-23 * * mon-fri"), shortcuts like "@daily" and intervals like
+// "@every 5m". Expressions may be prefixed with "TZ=<zone> " to be evaluated
+// in a time zone other than the local one.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	loc := time.Local
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("Missing expression after time zone in %q", spec)
+		}
+		var err error
+		loc, err = time.LoadLocation(spec[strings.Index(spec, "=")+1 : i])
+		if err != nil {
+			return nil, fmt.Errorf("Wrong time zone in %q: %v", spec, err)
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("Wrong interval in %q: %v", spec, err)
+		}
+		return Every(d), nil
+	}
+	expr := spec
+	if s, ok := shortcuts[spec]; ok {
+		expr = s
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Expected 5 fields in cron expression %q, got %d", spec, len(fields))
+	}
+	s := &exprSchedule{spec: spec, loc: loc}
+	var err error
+	if s.minute, err = parseField(fields[0], minuteRange); err != nil {
+		return nil, fmt.Errorf("Minute field of %q: %v", spec, err)
+	}
+	if s.hour, err = parseField(fields[1], hourRange); err != nil {
+		return nil, fmt.Errorf("Hour field of %q: %v", spec, err)
+	}
+	if s.dom, err = parseField(fields[2], domRange); err != nil {
+		return nil, fmt.Errorf("Day of month field of %q: %v", spec, err)
+	}
+	if s.month, err = parseField(fields[3], monthRange); err != nil {
+		return nil, fmt.Errorf("Month field of %q: %v", spec, err)
+	}
+	if s.dow, err = parseField(fields[4], dowRange); err != nil {
+		return nil, fmt.Errorf("Day of week field of %q: %v", spec, err)
+	}
+	// Both 0 and 7 are Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// MustParse is like Parse but panics on errors.
+func MustParse(spec string) Schedule {
+	s, err := Parse(spec)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func parseValue(s string, r fieldRange) (int, error) {
+	if v, ok := r.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("Wrong value %q", s)
+	}
+	if v < r.min || v > r.max {
+		return 0, fmt.Errorf("Value %d out of range %d-%d", v, r.min, r.max)
+	}
+	return v, nil
+}
+
+// parseField parses a comma separated list of values, ranges and steps into a
+// bit set.
+func parseField(field string, r fieldRange) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("Wrong step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		lo, hi := r.min, r.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if lo, err = parseValue(part[:i], r); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(part[i+1:], r); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("Wrong range %q", part)
+			}
+		default:
+			v, err := parseValue(part, r)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if step > 1 {
+				hi = r.max
+			} else {
+				hi = v
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+func (s *exprSchedule) dayMatches(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+	// Standard cron: if both day fields are restricted, either may match.
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (s *exprSchedule) Next(t time.Time) time.Time {
+	orig := t.Location()
+	// The expression is matched against the wall clock of s.loc, kept in UTC
+	// so daylight saving time changes do not skip or repeat times. Times
+	// skipped by the change run right after it, repeated ones run once.
+	lt := t.In(s.loc)
+	w := time.Date(lt.Year(), lt.Month(), lt.Day(), lt.Hour(), lt.Minute(), 0, 0, time.UTC).Add(time.Minute)
+
+	// Give up after a few years, the expression cannot match (like 30 Feb).
+	limit := w.AddDate(5, 0, 0)
+	for w.Before(limit) {
+		if !has(s.month, int(w.Month())) {
+			w = time.Date(w.Year(), w.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(w) {
+			w = time.Date(w.Year(), w.Month(), w.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !has(s.hour, w.Hour()) {
+			w = time.Date(w.Year(), w.Month(), w.Day(), w.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !has(s.minute, w.Minute()) {
+			w = w.Add(time.Minute)
+			continue
+		}
+		next := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), 0, 0, s.loc)
+		if !next.After(t) {
+			// The wall clock time was repeated after t.
+			w = w.Add(time.Minute)
+			continue
+		}
+		return next.In(orig)
+	}
+	return time.Time{}
+}
+
+func (s *exprSchedule) String() string {
+	if s.loc != time.Local {
+		return fmt.Sprintf("TZ=%s %s", s.loc, s.spec)
+	}
+	return s.spec
+}
@@ -0,0 +1,166 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * foo *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"1-x * * * *",
+		"@every",
+		"@every soon",
+		"@sometimes",
+		"TZ=Europe/Berlin",
+		"TZ=Nowhere/Atlantis 0 * * * *",
+	} {
+		if s, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", spec, s)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want string
+	}{
+		{"*/15 8-23 * * mon-fri", "*/15 8-23 * * mon-fri"},
+		{"  @daily ", "@daily"},
+		{"@every 5m", "@every 5m0s"},
+		{"@every 10ms", "@every 1s"},
+		{"TZ=Europe/Berlin 0 3 * * *", "TZ=Europe/Berlin 0 3 * * *"},
+		{"CRON_TZ=UTC @hourly", "TZ=UTC @hourly"},
+	} {
+		s, err := Parse(tc.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tc.spec, err)
+			continue
+		}
+		if got := s.String(); got != tc.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Saturday.
+	from := time.Date(2024, 6, 15, 10, 7, 30, 0, time.UTC)
+	for _, tc := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"TZ=UTC */15 * * * *", time.Date(2024, 6, 15, 10, 15, 0, 0, time.UTC)},
+		{"TZ=UTC 7 10 * * *", time.Date(2024, 6, 16, 10, 7, 0, 0, time.UTC)},
+		{"TZ=UTC 0 9 * * mon-fri", time.Date(2024, 6, 17, 9, 0, 0, 0, time.UTC)},
+		{"TZ=UTC 0 0 * * 7", time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC)},
+		{"TZ=UTC @monthly", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		// Either day field may match when both are restricted.
+		{"TZ=UTC 0 0 20 * sun", time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC)},
+		{"TZ=UTC 0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"TZ=UTC 0 0 30 2 *", time.Time{}},
+		{"TZ=Europe/Berlin 0 13 * * *", time.Date(2024, 6, 15, 11, 0, 0, 0, time.UTC)},
+		{"@every 1h", time.Date(2024, 6, 15, 11, 0, 0, 0, time.UTC)},
+	} {
+		if got := MustParse(tc.spec).Next(from); !got.Equal(tc.want) {
+			t.Errorf("Next(%v) of %q = %v, want %v", from, tc.spec, got, tc.want)
+		}
+	}
+}
+
+// runs returns the first n runs of spec after from.
+func runs(spec string, from time.Time, n int) []time.Time {
+	s := MustParse(spec)
+	var ts []time.Time
+	for t := from; len(ts) < n; {
+		t = s.Next(t)
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+func TestNextAcrossDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+	at := func(month time.Month, day, hour, min, offset int) time.Time {
+		return time.Date(2024, month, day, hour, min, 0, 0, time.FixedZone("", offset*3600))
+	}
+	for _, tc := range []struct {
+		name string
+		spec string
+		from time.Time
+		want []time.Time
+	}{
+		{
+			// 02:00 CET jumps to 03:00 CEST, the skipped time runs after
+			// the jump.
+			name: "daily at a skipped time",
+			spec: "TZ=Europe/Berlin 30 2 * * *",
+			from: time.Date(2024, 3, 30, 12, 0, 0, 0, berlin),
+			want: []time.Time{at(3, 31, 3, 30, 2), at(4, 1, 2, 30, 2)},
+		},
+		{
+			name: "every 20 minutes on spring forward",
+			spec: "TZ=Europe/Berlin */20 * * * *",
+			from: time.Date(2024, 3, 31, 1, 30, 0, 0, berlin),
+			want: []time.Time{at(3, 31, 1, 40, 1), at(3, 31, 3, 0, 2), at(3, 31, 3, 20, 2)},
+		},
+		{
+			// 03:00 CEST goes back to 02:00 CET, the repeated time runs
+			// once.
+			name: "daily at a repeated time",
+			spec: "TZ=Europe/Berlin 30 2 * * *",
+			from: time.Date(2024, 10, 26, 12, 0, 0, 0, berlin),
+			want: []time.Time{at(10, 27, 2, 30, 1), at(10, 28, 2, 30, 1)},
+		},
+		{
+			name: "daily after a repeated time",
+			spec: "TZ=Europe/Berlin 0 3 * * *",
+			from: time.Date(2024, 10, 26, 12, 0, 0, 0, berlin),
+			want: []time.Time{at(10, 27, 3, 0, 1), at(10, 28, 3, 0, 1)},
+		},
+	} {
+		got := runs(tc.spec, tc.from, len(tc.want))
+		for i := range tc.want {
+			if !got[i].Equal(tc.want[i]) {
+				t.Errorf("%s: runs = %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	cj := &CronJob{Schedule: Every(time.Minute), Jitter: 10 * time.Second}
+	from := time.Date(2024, 6, 15, 10, 7, 30, 0, time.UTC)
+	next := Every(time.Minute).Next(from)
+	jittered := false
+	for i := 0; i < 100; i++ {
+		got := cj.scheduleNext(from)
+		if got.Before(next) || !got.Before(next.Add(cj.Jitter)) {
+			t.Fatalf("scheduleNext() = %v, want within [%v, %v)", got, next, next.Add(cj.Jitter))
+		}
+		if !got.Equal(cj.NextRun) {
+			t.Errorf("NextRun = %v, want %v", cj.NextRun, got)
+		}
+		jittered = jittered || !got.Equal(next)
+	}
+	if !jittered {
+		t.Error("scheduleNext() never added jitter")
+	}
+}
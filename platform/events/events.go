@@ -0,0 +1,105 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Type of an event, also used as the SSE event name.
+type Type string
+
+const (
+	ItemAppeared    Type = "item_appeared"
+	ItemDisappeared Type = "item_disappeared"
+	MoveStarted     Type = "move_started"
+	MoveProgress    Type = "move_progress"
+	MoveFinished    Type = "move_finished"
+	TorrentState    Type = "torrent_state"
+	AssistantRun    Type = "assistant_run"
+	JobRun          Type = "job_run"
+	LogMessage      Type = "log_message"
+	DiskThreshold   Type = "disk_threshold"
+	Notification    Type = "notification"
+)
+
+// Event is published on the Bus. Data is specific to the type and is
+// serialized to JSON for clients.
+type Event struct {
+	ID     uint64      `json:"id"`
+	T      time.Time   `json:"t"`
+	Type   Type        `json:"type"`
+	Source string      `json:"source"`
+	Data   interface{} `json:"data"`
+}
+
+// Subscription receives events published after it was created.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	dropped int
+	bus     *Bus
+}
+
+// Dropped returns the number of events dropped because the subscriber did not
+// keep up.
+func (sub *Subscription) Dropped() int {
+	sub.bus.lock.Lock()
+	defer sub.bus.lock.Unlock()
+
+	return sub.dropped
+}
+
+// Close stops the subscription and closes C.
+func (sub *Subscription) Close() {
+	sub.bus.lock.Lock()
+	defer sub.bus.lock.Unlock()
+
+	if _, ok := sub.bus.subs[sub]; ok {
+		delete(sub.bus.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Bus delivers events to subscribers. Publishing never blocks, events are
+// dropped for subscribers whose buffer is full, so it is safe to publish
+// while holding other locks.
+type Bus struct {
+	subs   map[*Subscription]struct{}
+	nextID uint64
+	lock   sync.Mutex
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscribe returns a subscription buffering up to buffer events.
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	c := make(chan Event, buffer)
+	sub := &Subscription{C: c, c: c, bus: b}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Publish sends an event to all subscribers. A nil Bus drops all events.
+func (b *Bus) Publish(source string, t Type, data interface{}) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.nextID++
+	ev := Event{ID: b.nextID, T: time.Now(), Type: t, Source: source, Data: data}
+	for sub := range b.subs {
+		select {
+		case sub.c <- ev:
+		default:
+			sub.dropped++
+		}
+	}
+}
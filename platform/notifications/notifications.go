@@ -0,0 +1,125 @@
+package notifications
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/HawkMachine/kodi_automation/platform/events"
+)
+
+const maxNotifications = 200
+
+type Level string
+
+const (
+	Info    Level = "info"
+	Warning Level = "warning"
+	Error   Level = "error"
+)
+
+// Notification is a message for the user, e.g. about a failing cron job.
+type Notification struct {
+	ID     int       `json:"id"`
+	T      time.Time `json:"t"`
+	Level  Level     `json:"level"`
+	Source string    `json:"source"`
+	Title  string    `json:"title"`
+	Msg    string    `json:"msg"`
+	Read   bool      `json:"read"`
+}
+
+// Notifications keeps the most recent notifications, newest first.
+type Notifications struct {
+	list   []*Notification
+	nextID int
+	bus    *events.Bus
+	lock   sync.Mutex
+}
+
+func New() *Notifications {
+	return &Notifications{nextID: 1}
+}
+
+// SetEventBus sets the bus new notifications are published on.
+func (n *Notifications) SetEventBus(bus *events.Bus) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.bus = bus
+}
+
+// Add records a new unread notification.
+func (n *Notifications) Add(level Level, source, title, msg string) *Notification {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	nt := &Notification{
+		ID:     n.nextID,
+		T:      time.Now(),
+		Level:  level,
+		Source: source,
+		Title:  title,
+		Msg:    msg,
+	}
+	n.nextID++
+	log.Printf("Notification from %s: %s: %s\n", source, title, msg)
+
+	n.list = append([]*Notification{nt}, n.list...)
+	if len(n.list) > maxNotifications {
+		n.list = n.list[:maxNotifications]
+	}
+	n.bus.Publish(source, events.Notification, *nt)
+	return nt
+}
+
+// List returns copies of the notifications, newest first.
+func (n *Notifications) List() []Notification {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	var l []Notification
+	for _, nt := range n.list {
+		l = append(l, *nt)
+	}
+	return l
+}
+
+// Unread returns the number of unread notifications.
+func (n *Notifications) Unread() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	cnt := 0
+	for _, nt := range n.list {
+		if !nt.Read {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// MarkAllRead marks all notifications as read.
+func (n *Notifications) MarkAllRead() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, nt := range n.list {
+		nt.Read = true
+	}
+}
+
+// Dismiss removes the notification with the given id.
+func (n *Notifications) Dismiss(id int) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for i, nt := range n.list {
+		if nt.ID == id {
+			n.list = append(n.list[:i], n.list[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("Notification %d not found", id)
+}
@@ -0,0 +1,123 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/HawkMachine/kodi_automation/platform/events"
+)
+
+const sendTimeout = 30 * time.Second
+
+// Notifier sends events published on the bus to the configured sinks.
+type Notifier struct {
+	sinks []*sinkEntry
+	sub   *events.Subscription
+}
+
+// NewNotifier validates the sink configs and starts forwarding events.
+func NewNotifier(bus *events.Bus, configs []SinkConfig) (*Notifier, error) {
+	nf := &Notifier{}
+	for _, c := range configs {
+		e, err := newSinkEntry(c)
+		if err != nil {
+			return nil, err
+		}
+		nf.sinks = append(nf.sinks, e)
+	}
+	if len(nf.sinks) != 0 {
+		nf.sub = bus.Subscribe(100)
+		go nf.run()
+	}
+	return nf, nil
+}
+
+// Sinks returns names of the configured sinks.
+func (nf *Notifier) Sinks() []string {
+	var names []string
+	for _, e := range nf.sinks {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func (nf *Notifier) run() {
+	for ev := range nf.sub.C {
+		m, err := newMessage(ev)
+		if err != nil {
+			log.Printf("Notifier: converting %s event failed: %v\n", ev.Type, err)
+			continue
+		}
+		for _, e := range nf.sinks {
+			if e.accepts(m) {
+				go nf.send(e, m)
+			}
+		}
+	}
+}
+
+// newMessage builds a message from an event. The event data is converted to
+// fields through JSON so templates use the same names as the event stream.
+func newMessage(ev events.Event) (*Message, error) {
+	m := &Message{
+		Event:  string(ev.Type),
+		Source: ev.Source,
+		T:      ev.T,
+		Level:  Info,
+		Fields: map[string]interface{}{},
+	}
+	b, err := json.Marshal(ev.Data)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m.Fields); err != nil {
+		// Not an object, still available to templates.
+		m.Fields = map[string]interface{}{"data": ev.Data}
+	}
+	switch {
+	case ev.Type == events.Notification:
+		if l, ok := m.Fields["level"].(string); ok {
+			m.Level = Level(l)
+		}
+	case ev.Type == events.DiskThreshold:
+		m.Level = Warning
+	case m.Fields["error"] != nil && m.Fields["error"] != "":
+		m.Level = Error
+	}
+	return m, nil
+}
+
+// send delivers the message to a sink. Failures are only logged, reporting
+// them as notifications could fail the same way again.
+func (nf *Notifier) send(e *sinkEntry, m *Message) error {
+	r, err := e.render(m)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		err = e.sink.Send(ctx, r)
+	}
+	if err != nil {
+		err = fmt.Errorf("Sending %s to %s failed: %v", m.Event, e.Name, err)
+		log.Printf("Notifier: %v\n", err)
+	}
+	return err
+}
+
+// SendTest sends a test message to the named sink, ignoring its filters.
+func (nf *Notifier) SendTest(name string) error {
+	for _, e := range nf.sinks {
+		if e.Name == name {
+			return nf.send(e, &Message{
+				Event:  "test",
+				Source: "notifier",
+				T:      time.Now(),
+				Level:  Info,
+				Fields: map[string]interface{}{"name": e.Name, "type": e.Type},
+			})
+		}
+	}
+	return fmt.Errorf("Unknown sink %s", name)
+}
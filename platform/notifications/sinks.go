@@ -0,0 +1,459 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Message is sent by sinks, it is built from an event.
+type Message struct {
+	Event  string
+	Source string
+	T      time.Time
+	Level  Level
+	Fields map[string]interface{} // Data of the event.
+	Title  string                 // Rendered title.
+	Text   string                 // Rendered text.
+}
+
+// Sink delivers messages to an external service.
+type Sink interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// SinkConfig configures a sink and the messages sent to it.
+type SinkConfig struct {
+	Name string `json:"name"`
+
+	// One of "webhook", "ntfy", "gotify" or "smtp".
+	Type string `json:"type"`
+
+	// Endpoint of webhook, ntfy topic (https://ntfy.sh/topic) or Gotify
+	// server.
+	URL string `json:"url"`
+
+	// Gotify application token or ntfy access token.
+	Token string `json:"token"`
+
+	// Extra HTTP headers.
+	Headers map[string]string `json:"headers"`
+
+	// Priority of ntfy and Gotify messages, service default if zero.
+	Priority int `json:"priority"`
+
+	// SMTP server as host:port, sender, recipients and optional credentials.
+	SMTPAddr     string   `json:"smtp_addr"`
+	From         string   `json:"from"`
+	To           []string `json:"to"`
+	SMTPUsername string   `json:"smtp_username"`
+	SMTPPassword string   `json:"smtp_password"`
+
+	// Event types sent to the sink, defaults to move_finished,
+	// disk_threshold and notification.
+	Events []string `json:"events"`
+
+	// Types of log_message events to send, e.g. "MoveResult". All if empty.
+	LogTypes []string `json:"log_types"`
+
+	// Only messages of the level and above are sent, "info" (default),
+	// "warning" or "error".
+	MinLevel string `json:"min_level"`
+
+	// Go text templates for the title and text, executed with the Message.
+	// Empty means a default for the event type.
+	TitleTemplate string `json:"title_template"`
+	TextTemplate  string `json:"text_template"`
+
+	// Webhook only: template of the request body, executed with the Message
+	// with Title and Text rendered. Empty sends the whole Message as JSON.
+	// Telegram-compatible APIs can be used with e.g.
+	// {"chat_id": "123", "text": {{json .Text}}}
+	PayloadTemplate string `json:"payload_template"`
+}
+
+var defaultEvents = []string{"move_finished", "disk_threshold", "notification"}
+
+// Default templates by event type.
+var (
+	defaultTitles = map[string]string{
+		"move_finished":  `{{if .Fields.error}}Import of {{.Fields.name}} failed{{else}}Imported {{.Fields.name}}{{end}}`,
+		"disk_threshold": `Disk {{.Fields.path}} is {{.Fields.percent_full}}% full`,
+		"notification":   `{{.Fields.title}}`,
+		"log_message":    `{{.Fields.type}}`,
+		"test":           `Test notification`,
+		"":               `{{.Event}} from {{.Source}}`,
+	}
+	defaultTexts = map[string]string{
+		"move_finished":  `{{if .Fields.error}}{{.Fields.error}}{{else}}{{.Fields.name}} is in {{.Fields.target}}{{end}}`,
+		"disk_threshold": `Threshold is {{.Fields.threshold}}%.{{if .Fields.full_in}} Full in {{.Fields.full_in}}.{{end}}`,
+		"notification":   `{{.Fields.msg}}`,
+		"log_message":    `{{.Fields.msg}}`,
+		"test":           `Sink {{.Fields.name}} ({{.Fields.type}}) works.`,
+		"": `{{range $k, $v := .Fields}}{{$k}}: {{$v}}
+{{end}}`,
+	}
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseLevel(l string) (Level, error) {
+	switch Level(l) {
+	case "":
+		return Info, nil
+	case Info, Warning, Error:
+		return Level(l), nil
+	}
+	return "", fmt.Errorf("Unknown level %q", l)
+}
+
+func levelRank(l Level) int {
+	switch l {
+	case Warning:
+		return 1
+	case Error:
+		return 2
+	}
+	return 0
+}
+
+// sinkEntry is a configured sink with its filters and templates.
+type sinkEntry struct {
+	Name     string
+	Type     string
+	sink     Sink
+	events   map[string]bool
+	logTypes map[string]bool
+	minLevel Level
+	title    *template.Template
+	text     *template.Template
+	titles   map[string]*template.Template
+	texts    map[string]*template.Template
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+func parseTemplates(defaults map[string]string) (map[string]*template.Template, error) {
+	ts := map[string]*template.Template{}
+	for event, text := range defaults {
+		t, err := parseTemplate(event, text)
+		if err != nil {
+			return nil, err
+		}
+		ts[event] = t
+	}
+	return ts, nil
+}
+
+func newSinkEntry(c SinkConfig) (*sinkEntry, error) {
+	if c.Name == "" {
+		c.Name = c.Type
+	}
+	e := &sinkEntry{
+		Name:     c.Name,
+		Type:     c.Type,
+		events:   map[string]bool{},
+		logTypes: map[string]bool{},
+	}
+	var err error
+	if e.minLevel, err = parseLevel(c.MinLevel); err != nil {
+		return nil, fmt.Errorf("Sink %s: %v", c.Name, err)
+	}
+	events := c.Events
+	if len(events) == 0 {
+		events = defaultEvents
+	}
+	for _, ev := range events {
+		e.events[ev] = true
+	}
+	for _, lt := range c.LogTypes {
+		e.logTypes[lt] = true
+	}
+
+	if c.TitleTemplate != "" {
+		if e.title, err = parseTemplate("title", c.TitleTemplate); err != nil {
+			return nil, fmt.Errorf("Sink %s title template: %v", c.Name, err)
+		}
+	}
+	if c.TextTemplate != "" {
+		if e.text, err = parseTemplate("text", c.TextTemplate); err != nil {
+			return nil, fmt.Errorf("Sink %s text template: %v", c.Name, err)
+		}
+	}
+	if e.titles, err = parseTemplates(defaultTitles); err != nil {
+		return nil, err
+	}
+	if e.texts, err = parseTemplates(defaultTexts); err != nil {
+		return nil, err
+	}
+
+	switch c.Type {
+	case "webhook":
+		ws := &webhookSink{url: c.URL, headers: c.Headers}
+		if c.PayloadTemplate != "" {
+			if ws.payload, err = parseTemplate("payload", c.PayloadTemplate); err != nil {
+				return nil, fmt.Errorf("Sink %s payload template: %v", c.Name, err)
+			}
+		}
+		e.sink = ws
+	case "ntfy":
+		e.sink = &ntfySink{url: c.URL, token: c.Token, priority: c.Priority, headers: c.Headers}
+	case "gotify":
+		e.sink = &gotifySink{url: c.URL, token: c.Token, priority: c.Priority, headers: c.Headers}
+	case "smtp":
+		if c.SMTPAddr == "" || c.From == "" || len(c.To) == 0 {
+			return nil, fmt.Errorf("Sink %s: smtp_addr, from and to are required", c.Name)
+		}
+		e.sink = &smtpSink{addr: c.SMTPAddr, from: c.From, to: c.To, username: c.SMTPUsername, password: c.SMTPPassword}
+	default:
+		return nil, fmt.Errorf("Sink %s: unknown type %q", c.Name, c.Type)
+	}
+	if c.Type != "smtp" && c.URL == "" {
+		return nil, fmt.Errorf("Sink %s: url is required", c.Name)
+	}
+	return e, nil
+}
+
+// accepts returns true if the message passes the sink filters.
+func (e *sinkEntry) accepts(m *Message) bool {
+	if !e.events[m.Event] {
+		return false
+	}
+	if m.Event == "log_message" && len(e.logTypes) != 0 {
+		lt, _ := m.Fields["type"].(string)
+		if !e.logTypes[lt] {
+			return false
+		}
+	}
+	return levelRank(m.Level) >= levelRank(e.minLevel)
+}
+
+func execute(t *template.Template, m *Message) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, m); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// defaultTemplate returns the template for the event type, a generic one if
+// the type has none.
+func defaultTemplate(ts map[string]*template.Template, event string) *template.Template {
+	if t, ok := ts[event]; ok {
+		return t
+	}
+	return ts[""]
+}
+
+// render returns a copy of the message with the title and text rendered
+// with the sink templates.
+func (e *sinkEntry) render(m *Message) (*Message, error) {
+	title, text := e.title, e.text
+	if title == nil {
+		title = defaultTemplate(e.titles, m.Event)
+	}
+	if text == nil {
+		text = defaultTemplate(e.texts, m.Event)
+	}
+	var err error
+	r := *m
+	if r.Title, err = execute(title, m); err != nil {
+		return nil, fmt.Errorf("Title template: %v", err)
+	}
+	if r.Text, err = execute(text, m); err != nil {
+		return nil, fmt.Errorf("Text template: %v", err)
+	}
+	return &r, nil
+}
+
+// post makes a POST request and returns an error for non-2xx responses.
+func post(ctx context.Context, url, contentType string, body io.Reader, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", url, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// webhookSink posts the message as JSON, or the rendered payload template.
+type webhookSink struct {
+	url     string
+	headers map[string]string
+	payload *template.Template
+}
+
+func (ws *webhookSink) Send(ctx context.Context, m *Message) error {
+	var body []byte
+	if ws.payload != nil {
+		var b bytes.Buffer
+		if err := ws.payload.Execute(&b, m); err != nil {
+			return fmt.Errorf("Payload template: %v", err)
+		}
+		body = b.Bytes()
+	} else {
+		var err error
+		body, err = json.Marshal(struct {
+			Event  string                 `json:"event"`
+			Source string                 `json:"source"`
+			T      time.Time              `json:"t"`
+			Level  Level                  `json:"level"`
+			Title  string                 `json:"title"`
+			Text   string                 `json:"text"`
+			Fields map[string]interface{} `json:"fields"`
+		}{m.Event, m.Source, m.T, m.Level, m.Title, m.Text, m.Fields})
+		if err != nil {
+			return err
+		}
+	}
+	return post(ctx, ws.url, "application/json", bytes.NewReader(body), ws.headers)
+}
+
+// ntfySink publishes to an ntfy topic, the title and priority go in headers.
+type ntfySink struct {
+	url      string
+	token    string
+	priority int
+	headers  map[string]string
+}
+
+func (ns *ntfySink) Send(ctx context.Context, m *Message) error {
+	headers := map[string]string{"Title": m.Title}
+	if ns.priority != 0 {
+		headers["Priority"] = strconv.Itoa(ns.priority)
+	}
+	if m.Level == Error {
+		headers["Tags"] = "warning"
+	}
+	if ns.token != "" {
+		headers["Authorization"] = "Bearer " + ns.token
+	}
+	for k, v := range ns.headers {
+		headers[k] = v
+	}
+	return post(ctx, ns.url, "text/plain; charset=utf-8", strings.NewReader(m.Text), headers)
+}
+
+// gotifySink posts to the message endpoint of a Gotify server.
+type gotifySink struct {
+	url      string
+	token    string
+	priority int
+	headers  map[string]string
+}
+
+func (gs *gotifySink) Send(ctx context.Context, m *Message) error {
+	msg := map[string]interface{}{
+		"title":   m.Title,
+		"message": m.Text,
+	}
+	if gs.priority != 0 {
+		msg["priority"] = gs.priority
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"X-Gotify-Key": gs.token}
+	for k, v := range gs.headers {
+		headers[k] = v
+	}
+	return post(ctx, strings.TrimRight(gs.url, "/")+"/message", "application/json", bytes.NewReader(body), headers)
+}
+
+// smtpSink sends the message as a plain text email.
+type smtpSink struct {
+	addr     string
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+func (ss *smtpSink) Send(ctx context.Context, m *Message) error {
+	host, _, err := net.SplitHostPort(ss.addr)
+	if err != nil {
+		return err
+	}
+	// Header values must not contain line breaks.
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(m.Title)
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		ss.from, strings.Join(ss.to, ", "), subject, m.T.Format(time.RFC1123Z), m.Text)
+
+	// The same steps as smtp.SendMail, on a connection that gives up when
+	// ctx is done.
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", ss.addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if ss.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", ss.username, ss.password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(ss.from); err != nil {
+		return err
+	}
+	for _, to := range ss.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
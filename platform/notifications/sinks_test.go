@@ -0,0 +1,250 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/HawkMachine/kodi_automation/platform/events"
+)
+
+// request is a request received by the test server.
+type request struct {
+	Path   string
+	Header http.Header
+	Body   string
+}
+
+// newTestServer returns a server recording the requests it gets.
+func newTestServer(t *testing.T) (*httptest.Server, chan request) {
+	reqs := make(chan request, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Reading request body failed: %v", err)
+		}
+		reqs <- request{Path: r.URL.Path, Header: r.Header, Body: string(b)}
+	}))
+	return ts, reqs
+}
+
+// receive returns the next request received by the test server.
+func receive(t *testing.T, reqs chan request) request {
+	t.Helper()
+	select {
+	case r := <-reqs:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("No request received")
+	}
+	return request{}
+}
+
+// expectNoRequest fails if the test server gets a request soon.
+func expectNoRequest(t *testing.T, reqs chan request) {
+	t.Helper()
+	select {
+	case r := <-reqs:
+		t.Errorf("Unexpected request: %+v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func newTestNotifier(t *testing.T, c SinkConfig) *events.Bus {
+	bus := events.NewBus()
+	if _, err := NewNotifier(bus, []SinkConfig{c}); err != nil {
+		t.Fatalf("NewNotifier() failed: %v", err)
+	}
+	return bus
+}
+
+func TestWebhookSinkPayloadTemplate(t *testing.T) {
+	ts, reqs := newTestServer(t)
+	defer ts.Close()
+
+	bus := newTestNotifier(t, SinkConfig{
+		Type:            "webhook",
+		URL:             ts.URL + "/hook",
+		Headers:         map[string]string{"X-Test": "yes"},
+		TitleTemplate:   `Done: {{.Fields.name}}`,
+		PayloadTemplate: `{"chat_id": "123", "text": {{json .Title}}}`,
+	})
+	bus.Publish("moveserver", events.MoveFinished, map[string]string{"name": "Movie", "target": "/movies"})
+
+	r := receive(t, reqs)
+	if r.Path != "/hook" {
+		t.Errorf("Path = %q, want /hook", r.Path)
+	}
+	if got := r.Header.Get("X-Test"); got != "yes" {
+		t.Errorf("X-Test header = %q, want yes", got)
+	}
+	if want := `{"chat_id": "123", "text": "Done: Movie"}`; r.Body != want {
+		t.Errorf("Body = %s, want %s", r.Body, want)
+	}
+}
+
+func TestWebhookSinkDefaultPayload(t *testing.T) {
+	ts, reqs := newTestServer(t)
+	defer ts.Close()
+
+	bus := newTestNotifier(t, SinkConfig{Type: "webhook", URL: ts.URL})
+	bus.Publish("moveserver", events.MoveFinished, map[string]string{"name": "Movie", "error": "disk full"})
+
+	var got struct {
+		Event string `json:"event"`
+		Level Level  `json:"level"`
+		Title string `json:"title"`
+		Text  string `json:"text"`
+	}
+	if err := json.Unmarshal([]byte(receive(t, reqs).Body), &got); err != nil {
+		t.Fatalf("Decoding body failed: %v", err)
+	}
+	if got.Event != "move_finished" || got.Level != Error {
+		t.Errorf("Event, level = %s, %s, want move_finished, error", got.Event, got.Level)
+	}
+	if got.Title != "Import of Movie failed" || got.Text != "disk full" {
+		t.Errorf("Title, text = %q, %q, want the move_finished defaults", got.Title, got.Text)
+	}
+}
+
+func TestNtfySink(t *testing.T) {
+	ts, reqs := newTestServer(t)
+	defer ts.Close()
+
+	bus := newTestNotifier(t, SinkConfig{
+		Type:         "ntfy",
+		URL:          ts.URL + "/topic",
+		Token:        "secret",
+		Priority:     4,
+		TextTemplate: `{{.Fields.path}} at {{.Fields.percent_full}}%`,
+	})
+	bus.Publish("moveserver", events.DiskThreshold, map[string]interface{}{"path": "/mnt/a", "percent_full": 95, "threshold": 90})
+
+	r := receive(t, reqs)
+	if r.Path != "/topic" {
+		t.Errorf("Path = %q, want /topic", r.Path)
+	}
+	for h, want := range map[string]string{
+		"Title":         "Disk /mnt/a is 95% full",
+		"Priority":      "4",
+		"Authorization": "Bearer secret",
+	} {
+		if got := r.Header.Get(h); got != want {
+			t.Errorf("%s header = %q, want %q", h, got, want)
+		}
+	}
+	if want := "/mnt/a at 95%"; r.Body != want {
+		t.Errorf("Body = %q, want %q", r.Body, want)
+	}
+}
+
+func TestGotifySink(t *testing.T) {
+	ts, reqs := newTestServer(t)
+	defer ts.Close()
+
+	bus := newTestNotifier(t, SinkConfig{
+		Type:          "gotify",
+		URL:           ts.URL + "/",
+		Token:         "apptoken",
+		TitleTemplate: `[{{.Source}}] {{.Fields.title}}`,
+	})
+	bus.Publish("cron", events.Notification, map[string]string{"level": "error", "title": "Job failing", "msg": "3 failures"})
+
+	r := receive(t, reqs)
+	if r.Path != "/message" {
+		t.Errorf("Path = %q, want /message", r.Path)
+	}
+	if got := r.Header.Get("X-Gotify-Key"); got != "apptoken" {
+		t.Errorf("X-Gotify-Key header = %q, want apptoken", got)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(r.Body), &got); err != nil {
+		t.Fatalf("Decoding body failed: %v", err)
+	}
+	if got["title"] != "[cron] Job failing" || got["message"] != "3 failures" {
+		t.Errorf("Body = %s, want the rendered title and text", r.Body)
+	}
+	if _, ok := got["priority"]; ok {
+		t.Errorf("Body = %s, want no priority", r.Body)
+	}
+}
+
+func TestSinkEventFilter(t *testing.T) {
+	ts, reqs := newTestServer(t)
+	defer ts.Close()
+
+	bus := newTestNotifier(t, SinkConfig{
+		Type:     "webhook",
+		URL:      ts.URL,
+		Events:   []string{"move_finished"},
+		MinLevel: "error",
+	})
+	bus.Publish("moveserver", events.DiskThreshold, map[string]interface{}{"path": "/mnt/a"})
+	bus.Publish("moveserver", events.MoveFinished, map[string]string{"name": "Ok"})
+	bus.Publish("moveserver", events.MoveFinished, map[string]string{"name": "Failed", "error": "disk full"})
+
+	var got struct {
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal([]byte(receive(t, reqs).Body), &got); err != nil {
+		t.Fatalf("Decoding body failed: %v", err)
+	}
+	if got.Title != "Import of Failed failed" {
+		t.Errorf("Title = %q, want the failed move only", got.Title)
+	}
+	expectNoRequest(t, reqs)
+}
+
+func TestSinkLogTypesFilter(t *testing.T) {
+	ts, reqs := newTestServer(t)
+	defer ts.Close()
+
+	bus := newTestNotifier(t, SinkConfig{
+		Type:         "ntfy",
+		URL:          ts.URL,
+		Events:       []string{"log_message"},
+		LogTypes:     []string{"MoveResult"},
+		TextTemplate: `{{.Fields.type}}: {{.Fields.msg}}`,
+	})
+	bus.Publish("moveserver", events.LogMessage, map[string]string{"type": "UpdateCache", "msg": "listing failed"})
+	bus.Publish("moveserver", events.LogMessage, map[string]string{"type": "MoveResult", "msg": "moved"})
+
+	if r := receive(t, reqs); r.Body != "MoveResult: moved" {
+		t.Errorf("Body = %q, want the MoveResult message only", r.Body)
+	}
+	expectNoRequest(t, reqs)
+}
+
+func TestSMTPSinkGivesUpOnDeadline(t *testing.T) {
+	// The server accepts connections but never greets.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ss := &smtpSink{addr: l.Addr().String(), from: "kodi@example.com", to: []string{"me@example.com"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := ss.Send(ctx, &Message{Title: "Test", Text: "Test", T: time.Now()}); err == nil {
+		t.Error("Send() succeeded, want a timeout")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Send() took %v, want it to give up on the deadline", d)
+	}
+}
@@ -1,7 +1,11 @@
 package platform
 
 import (
+	"fmt"
+
 	"github.com/HawkMachine/kodi_automation/platform/cron"
+	"github.com/HawkMachine/kodi_automation/platform/events"
+	"github.com/HawkMachine/kodi_automation/platform/notifications"
 )
 
 type KodiConfig struct {
@@ -39,13 +43,38 @@ func NewConfigFromStrings(
 }
 
 type Platform struct {
-	Cron   *cron.Cron
-	Config Config
+	Cron          *cron.Cron
+	Events        *events.Bus
+	Notifications *notifications.Notifications
+	Notifier      *notifications.Notifier
+	Config        Config
 }
 
 func New(c Config) *Platform {
-	return &Platform{
-		Cron:   cron.New(),
-		Config: c,
+	p := &Platform{
+		Cron:          cron.New(),
+		Events:        events.NewBus(),
+		Notifications: notifications.New(),
+		Config:        c,
+	}
+	p.Cron.SetEventBus(p.Events)
+	p.Notifications.SetEventBus(p.Events)
+	p.Cron.SetFailureHook(func(job string, failures int, err error) {
+		p.Notifications.Add(
+			notifications.Error,
+			"cron",
+			fmt.Sprintf("Cron job %s is failing", job),
+			fmt.Sprintf("%d consecutive failures, last error: %v", failures, err))
+	})
+	return p
+}
+
+// StartNotifier starts sending events to the notification sinks.
+func (p *Platform) StartNotifier(sinks []notifications.SinkConfig) error {
+	nf, err := notifications.NewNotifier(p.Events, sinks)
+	if err != nil {
+		return err
 	}
+	p.Notifier = nf
+	return nil
 }
@@ -0,0 +1,33 @@
+// CSRF protection of requests sent in a session.
+//
+// csrfProtectForms adds the token of the csrf-token meta tag as a hidden field
+// to POST forms under root. It runs on page load, live.js runs it for
+// reloaded sections.
+function csrfProtectForms(root) {
+	var token = $('meta[name="csrf-token"]').attr('content');
+	if (!token) {
+		return;
+	}
+	$(root).find('form').each(function() {
+		if ((this.getAttribute('method') || '').toLowerCase() != 'post') {
+			return;
+		}
+		if (!$(this).find('input[name="csrf_token"]').length) {
+			$('<input type="hidden" name="csrf_token">').val(token).appendTo(this);
+		}
+	});
+}
+
+$(document).ready(function() {
+	csrfProtectForms(document);
+});
+
+// Requests sent with jQuery carry the token in a header.
+$.ajaxSetup({
+	beforeSend: function(xhr, settings) {
+		var token = $('meta[name="csrf-token"]').attr('content');
+		if (token && !/^(GET|HEAD|OPTIONS)$/i.test(settings.type)) {
+			xhr.setRequestHeader('X-CSRF-Token', token);
+		}
+	}
+});
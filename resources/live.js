@@ -0,0 +1,55 @@
+// Live page updates from the /events stream.
+//
+// liveSection reloads the page section from the server when one of the given
+// event types arrives. Reloads are debounced, a burst of events results in a
+// single reload once it is quiet for a second, or 5 seconds after the burst
+// started if it goes on. Reloads wait while a form field in the section has
+// focus so typing is not lost.
+function liveSection(types) {
+	if (window.liveSectionStarted || !window.EventSource) {
+		return;
+	}
+	window.liveSectionStarted = true;
+
+	var pending = null;
+	var burstStart = 0;
+	function schedule() {
+		var now = Date.now();
+		if (pending) {
+			clearTimeout(pending);
+		} else {
+			burstStart = now;
+		}
+		pending = setTimeout(reload, Math.max(0, Math.min(1000, burstStart + 5000 - now)));
+	}
+	function reload() {
+		pending = null;
+		var section = $('#section');
+		if (section.find(':focus').length) {
+			schedule();
+			return;
+		}
+		$.get(window.location.href, function(html) {
+			var fresh = $('<div>').append($.parseHTML(html, document, true)).find('#section');
+			if (!fresh.length) {
+				return;
+			}
+			section.html(fresh.html());
+			csrfProtectForms(section);
+			// Angular Material elements in the new content have to be compiled.
+			var injector = angular.element(document.body).injector();
+			if (injector) {
+				injector.invoke(['$compile', '$rootScope', function($compile, $rootScope) {
+					$compile(section.contents())($rootScope);
+					$rootScope.$digest();
+				}]);
+			}
+		});
+	}
+
+	var base = $('meta[name="base-path"]').attr('content') || '';
+	var source = new EventSource(base + '/events?types=' + types.join(','));
+	types.forEach(function(t) {
+		source.addEventListener(t, schedule);
+	});
+}
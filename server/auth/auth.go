@@ -1,52 +1,297 @@
 package auth
 
 import (
-	"encoding/base64"
-	"fmt"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
-type BasicAuthAuthenticator interface {
-	BasicAuthAuthenticate(username, password string) (bool, error)
+// Name of the session cookie.
+const SessionCookie = "kh_session"
+
+// Name of the form field and header carrying the CSRF token.
+const (
+	CSRFField  = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	sessionKey
+	tokenKey
+	proxyHTTPSKey
+	basePathKey
+)
+
+// Auth authenticates requests with a session cookie or, for API clients,
+// basic auth or a bearer token. Authentication is disabled if there are no
+// users.
+type Auth struct {
+	users    *Users
+	tokens   *Tokens
+	sessions *Sessions
 }
 
-func basicAuthAuthenticate(a BasicAuthAuthenticator, r *http.Request) (bool, error) {
-	authorization := r.Header.Get("Authorization")
-	items := strings.SplitN(authorization, " ", 2)
-	if len(items) != 2 || items[0] != "Basic" {
-		return false, nil
+func New(users *Users, tokens *Tokens, sessionTTL time.Duration) *Auth {
+	return &Auth{users: users, tokens: tokens, sessions: NewSessions(sessionTTL)}
+}
+
+// Enabled returns true if requests have to be authenticated.
+func (a *Auth) Enabled() bool {
+	return !a.users.Empty()
+}
+
+// UserFromRequest returns the authenticated user, nil if authentication is
+// disabled.
+func UserFromRequest(r *http.Request) *User {
+	u, _ := r.Context().Value(userKey).(*User)
+	return u
+}
+
+// Allowed returns true if the user of the request has the role or a higher
+// one. Everyone is allowed if authentication is disabled.
+func Allowed(r *http.Request, role Role) bool {
+	u := UserFromRequest(r)
+	return u == nil || u.Role.Allows(role)
+}
+
+// TokenFromRequest returns the API token the request was authenticated with,
+// nil for users.
+func TokenFromRequest(r *http.Request) *Token {
+	t, _ := r.Context().Value(tokenKey).(*Token)
+	return t
+}
+
+// SessionFromRequest returns the session of the request, nil for basic auth
+// requests.
+func SessionFromRequest(r *http.Request) *Session {
+	s, _ := r.Context().Value(sessionKey).(*Session)
+	return s
+}
+
+// CSRFToken returns the token forms posted in the session must carry.
+func CSRFToken(r *http.Request) string {
+	if s := SessionFromRequest(r); s != nil {
+		return s.CSRFToken
 	}
+	return ""
+}
 
-	decoded, err := base64.StdEncoding.DecodeString(items[1])
+// authenticate returns the user of the request, and its session or token if
+// it was authenticated with one. Tokens act as operators limited by their
+// scopes.
+func (a *Auth) authenticate(r *http.Request) (*User, *Session, *Token) {
+	if username, password, ok := r.BasicAuth(); ok {
+		user, ok := a.users.Authenticate(username, password)
+		if !ok {
+			return nil, nil, nil
+		}
+		return user, nil, nil
+	}
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		t := a.tokens.use(strings.TrimPrefix(h, "Bearer "), r.Method, r.URL.Path)
+		if t == nil {
+			return nil, nil, nil
+		}
+		return &User{Username: "token:" + t.Name, Role: Operator}, nil, t
+	}
+	c, err := r.Cookie(SessionCookie)
 	if err != nil {
-		return false, fmt.Errorf("Base64 decoding error")
+		return nil, nil, nil
+	}
+	s := a.sessions.Get(c.Value)
+	if s == nil {
+		return nil, nil, nil
 	}
+	user := a.users.Get(s.Username)
+	if user == nil {
+		a.sessions.Delete(s.ID)
+		return nil, nil, nil
+	}
+	return user, s, nil
+}
+
+// checkCSRF returns true if a state changing request carries the token of its
+// session. Bearer tokens are never sent by browsers on their own and need no
+// CSRF token. Browsers do send remembered basic auth credentials, basic auth
+// requests have no session so they must not come from another site.
+func checkCSRF(r *http.Request, s *Session, t *Token) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if t != nil {
+		return true
+	}
+	if s == nil {
+		return sameOrigin(r)
+	}
+	token := r.Header.Get(CSRFHeader)
+	if token == "" {
+		token = r.FormValue(CSRFField)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) == 1
+}
 
-	authItems := strings.SplitN(string(decoded), ":", 2)
-	if len(authItems) != 2 {
-		return false, fmt.Errorf("Did not found \":\" in basic auth")
+// sameOrigin returns false for requests a browser sends from another site.
+// Browsers set Sec-Fetch-Site or at least Origin on such requests, requests
+// with neither do not come from a browser.
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return false
 	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
 
-	return a.BasicAuthAuthenticate(authItems[0], authItems[1])
+// wantsLoginPage returns true for requests of browsers navigating to a page,
+// they are redirected to the login page instead of getting a 401.
+func wantsLoginPage(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		r.Header.Get("Authorization") == "" &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
 }
 
-func BasicAuthWrap(a BasicAuthAuthenticator, f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
-	// Basic auth implemented basing on:
-	// https://en.wikipedia.org/wiki/Basic_access_authentication#Protocol
+// WantsJSON returns true for requests of API clients, their errors are
+// written in the envelope of the JSON API.
+func WantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/v1/") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
 
+// Error writes an error response. API clients get the JSON API envelope
+// {"error": {"code": ..., "message": ...}}, others plain text.
+func Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if !WantsJSON(r) {
+		http.Error(w, message, status)
+		return
+	}
+	var resp struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	resp.Error.Code = code
+	resp.Error.Message = message
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Writing error response failed: %v\n", err)
+	}
+}
+
+// Wrap returns a handler that only calls f for authenticated requests with a
+// valid CSRF token. The user, session and token are available to f through
+// UserFromRequest, SessionFromRequest and TokenFromRequest.
+func (a *Auth) Wrap(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ok, err := basicAuthAuthenticate(a, r)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if !a.Enabled() {
+			f(w, r)
 			return
 		}
-		if ok {
-			f(w, r)
-		} else {
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"test\"")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("401 Unauthorized"))
+		user, s, t := a.authenticate(r)
+		if user == nil {
+			if wantsLoginPage(r) {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Basic realm=\"Kodi Helper\"")
+			Error(w, r, http.StatusUnauthorized, "unauthorized", "401 Unauthorized")
+			return
+		}
+		if !checkCSRF(r, s, t) {
+			Error(w, r, http.StatusForbidden, "csrf_failed", "Missing or invalid CSRF token")
+			return
 		}
+		ctx := context.WithValue(r.Context(), userKey, user)
+		if s != nil {
+			ctx = context.WithValue(ctx, sessionKey, s)
+		}
+		if t != nil {
+			ctx = context.WithValue(ctx, tokenKey, t)
+		}
+		f(w, r.WithContext(ctx))
+	}
+}
+
+// WithProxyHTTPS returns the request marked as forwarded by a trusted proxy
+// the client connected to with HTTPS. Only the proxy handling may mark
+// requests, clients must not be able to.
+func WithProxyHTTPS(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), proxyHTTPSKey, true))
+}
+
+// isHTTPS returns true if the client connected with HTTPS, directly or to a
+// trusted proxy.
+func isHTTPS(r *http.Request) bool {
+	proxyHTTPS, _ := r.Context().Value(proxyHTTPSKey).(bool)
+	return r.TLS != nil || proxyHTTPS
+}
+
+// WithBasePath returns the request marked as served under the base path, e.g.
+// "/kodi". Session cookies are limited to it.
+func WithBasePath(r *http.Request, basePath string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), basePathKey, basePath))
+}
+
+// cookiePath returns the path of session cookies set in response to r.
+func cookiePath(r *http.Request) string {
+	if basePath, _ := r.Context().Value(basePathKey).(string); basePath != "" {
+		return basePath
+	}
+	return "/"
+}
+
+// Login starts a session if the credentials are valid and sets its cookie.
+func (a *Auth) Login(w http.ResponseWriter, r *http.Request, username, password string) (bool, error) {
+	user, ok := a.users.Authenticate(username, password)
+	if !ok {
+		return false, nil
+	}
+	s, err := a.sessions.Create(user.Username)
+	if err != nil {
+		return false, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    s.ID,
+		Path:     cookiePath(r),
+		Expires:  s.Expires,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return true, nil
+}
+
+// Logout ends the session of the request and clears its cookie.
+func (a *Auth) Logout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		a.sessions.Delete(c.Value)
 	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     cookiePath(r),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
 }
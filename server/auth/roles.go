@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"fmt"
+)
+
+// Role of a user, each role includes the permissions of the previous one.
+type Role string
+
+const (
+	// Viewer can browse the library.
+	Viewer Role = "viewer"
+
+	// Operator can also move files, manage torrents and the Assistant.
+	Operator Role = "operator"
+
+	// Admin can also control cron jobs and notification sinks.
+	Admin Role = "admin"
+)
+
+func ParseRole(s string) (Role, error) {
+	switch Role(s) {
+	case Viewer, Operator, Admin:
+		return Role(s), nil
+	}
+	return "", fmt.Errorf("Unknown role %q, expected viewer, operator or admin", s)
+}
+
+func (r Role) rank() int {
+	switch r {
+	case Viewer:
+		return 1
+	case Operator:
+		return 2
+	case Admin:
+		return 3
+	}
+	return 0
+}
+
+// Allows returns true if the role includes the permissions of required.
+func (r Role) Allows(required Role) bool {
+	return r.rank() >= required.rank()
+}
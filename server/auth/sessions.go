@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// Session of a logged in user. Sessions are kept in memory, a restart logs
+// everyone out.
+type Session struct {
+	ID        string
+	Username  string
+	CSRFToken string
+	Expires   time.Time
+}
+
+// Sessions keeps sessions by ID. Sessions expire ttl after login.
+type Sessions struct {
+	ttl      time.Duration
+	sessions map[string]*Session
+	lock     sync.Mutex
+}
+
+func NewSessions(ttl time.Duration) *Sessions {
+	return &Sessions{ttl: ttl, sessions: map[string]*Session{}}
+}
+
+// randomToken returns 32 random bytes encoded for use in cookies and forms.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Create starts a new session of the user.
+func (ss *Sessions) Create(username string) (*Session, error) {
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	now := time.Now()
+	for id, s := range ss.sessions {
+		if now.After(s.Expires) {
+			delete(ss.sessions, id)
+		}
+	}
+	s := &Session{ID: id, Username: username, CSRFToken: csrf, Expires: now.Add(ss.ttl)}
+	ss.sessions[id] = s
+	c := *s
+	return &c, nil
+}
+
+// Get returns a copy of the session, nil if it does not exist or expired.
+func (ss *Sessions) Get(id string) *Session {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	s, ok := ss.sessions[id]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(s.Expires) {
+		delete(ss.sessions, id)
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+// Delete ends the session.
+func (ss *Sessions) Delete(id string) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	delete(ss.sessions, id)
+}
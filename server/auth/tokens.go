@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scope limits what an API token can do.
+type Scope string
+
+const (
+	// ScopeRead allows handlers marked read-only.
+	ScopeRead Scope = "read"
+
+	// ScopeMove allows moving paths and controlling the Assistant.
+	ScopeMove Scope = "move"
+
+	// ScopeTransmission allows adding and changing torrents.
+	ScopeTransmission Scope = "transmission"
+
+	// ScopeKodiControl allows the Kodi stats, health and library handlers,
+	// which query Kodi, without the read scope.
+	ScopeKodiControl Scope = "kodi-control"
+)
+
+// Scopes lists all scopes.
+var Scopes = []Scope{ScopeRead, ScopeMove, ScopeTransmission, ScopeKodiControl}
+
+func ParseScope(s string) (Scope, error) {
+	for _, sc := range Scopes {
+		if Scope(s) == sc {
+			return sc, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown scope %q", s)
+}
+
+// Use of a token is persisted at most that often, it is logged every time.
+const tokenSaveInterval = time.Minute
+
+// Token is a long-lived credential for scripts, sent as a bearer token. Only
+// the SHA-256 hash of the secret is stored.
+type Token struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []Scope   `json:"scopes"`
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"created_by"`
+	LastUsed  time.Time `json:"last_used"`
+	Uses      int       `json:"uses"`
+}
+
+// HasScope returns true if the token has the scope.
+func (t *Token) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows returns true if the token may use a handler requiring the role and
+// scope. Read-only handlers are also allowed with ScopeRead. Whether a
+// handler is read-only is declared by the handler, not inferred from the
+// request method. Handlers for admins are not available to tokens.
+func (t *Token) Allows(role Role, scope Scope, readOnly bool) bool {
+	if role.Allows(Admin) {
+		return false
+	}
+	if scope != "" && t.HasScope(scope) {
+		return true
+	}
+	return readOnly && t.HasScope(ScopeRead)
+}
+
+type tokensFile struct {
+	Tokens []*Token `json:"tokens"`
+}
+
+// Tokens is a store of API tokens persisted to a JSON file.
+type Tokens struct {
+	path   string
+	tokens map[string]*Token // By hash.
+	saved  time.Time
+	lock   sync.Mutex
+}
+
+func hashToken(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// LoadTokens reads tokens from the file at path. A missing file is an empty
+// store, an empty path keeps tokens in memory only.
+func LoadTokens(path string) (*Tokens, error) {
+	ts := &Tokens{path: path, tokens: map[string]*Token{}}
+	if path == "" {
+		return ts, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f tokensFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("Parsing tokens file %s failed: %v", path, err)
+	}
+	for _, t := range f.Tokens {
+		ts.tokens[t.Hash] = t
+	}
+	return ts, nil
+}
+
+func (ts *Tokens) saveLocked() error {
+	ts.saved = time.Now()
+	if ts.path == "" {
+		return nil
+	}
+	f := tokensFile{Tokens: ts.listLocked()}
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(ts.path), ".tokens")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ts.path)
+}
+
+func (ts *Tokens) listLocked() []*Token {
+	var l []*Token
+	for _, t := range ts.tokens {
+		l = append(l, t)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l
+}
+
+// List returns copies of the tokens sorted by name.
+func (ts *Tokens) List() []Token {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	var l []Token
+	for _, t := range ts.listLocked() {
+		c := *t
+		l = append(l, c)
+	}
+	return l
+}
+
+// Create adds a token and returns its secret, which is not stored and cannot
+// be shown again.
+func (ts *Tokens) Create(name string, scopes []Scope, createdBy string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("Token name cannot be empty")
+	}
+	if len(scopes) == 0 {
+		return "", fmt.Errorf("Token %s needs at least one scope", name)
+	}
+	secret, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	secret = "kh_" + secret
+
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	for _, t := range ts.tokens {
+		if t.Name == name {
+			return "", fmt.Errorf("Token %s already exists", name)
+		}
+	}
+	t := &Token{
+		Name:      name,
+		Hash:      hashToken(secret),
+		Scopes:    scopes,
+		Created:   time.Now(),
+		CreatedBy: createdBy,
+	}
+	ts.tokens[t.Hash] = t
+	if err := ts.saveLocked(); err != nil {
+		delete(ts.tokens, t.Hash)
+		return "", err
+	}
+	return secret, nil
+}
+
+// Revoke deletes the token with the given name.
+func (ts *Tokens) Revoke(name string) error {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	for hash, t := range ts.tokens {
+		if t.Name == name {
+			delete(ts.tokens, hash)
+			return ts.saveLocked()
+		}
+	}
+	return fmt.Errorf("Token %s not found", name)
+}
+
+// use returns a copy of the token with the secret and records its use.
+func (ts *Tokens) use(secret, method, path string) *Token {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	t, ok := ts.tokens[hashToken(secret)]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	t.LastUsed = now
+	t.Uses++
+	log.Printf("Token %s used for %s %s\n", t.Name, method, path)
+	if now.Sub(ts.saved) >= tokenSaveInterval {
+		if err := ts.saveLocked(); err != nil {
+			log.Printf("Saving tokens failed: %v\n", err)
+		}
+	}
+	c := *t
+	return &c
+}
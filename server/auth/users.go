@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is an account that can log in. Only the bcrypt hash of the password is
+// stored.
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+
+	// Users from before roles existed could do everything, an empty role is
+	// Admin.
+	Role Role `json:"role,omitempty"`
+}
+
+type usersFile struct {
+	Users []*User `json:"users"`
+}
+
+// Users is a store of users persisted to a JSON file.
+type Users struct {
+	path  string
+	users map[string]*User
+	lock  sync.Mutex
+}
+
+// Compared against when the user does not exist, so that unknown users take
+// as long to reject as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// LoadUsers reads users from the file at path. A missing file is an empty
+// store, an empty path keeps users in memory only.
+func LoadUsers(path string) (*Users, error) {
+	u := &Users{path: path, users: map[string]*User{}}
+	if path == "" {
+		return u, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f usersFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("Parsing users file %s failed: %v", path, err)
+	}
+	for _, user := range f.Users {
+		if user.Username == "" || user.PasswordHash == "" {
+			return nil, fmt.Errorf("Users file %s has a user without name or password hash", path)
+		}
+		if user.Role == "" {
+			user.Role = Admin
+		}
+		if _, err := ParseRole(string(user.Role)); err != nil {
+			return nil, fmt.Errorf("Users file %s, user %s: %v", path, user.Username, err)
+		}
+		u.users[user.Username] = user
+	}
+	return u, nil
+}
+
+// saveLocked writes the users to the file via a temporary file, so that a
+// crash does not leave a truncated file behind.
+func (u *Users) saveLocked() error {
+	if u.path == "" {
+		return nil
+	}
+	var f usersFile
+	for _, user := range u.users {
+		f.Users = append(f.Users, user)
+	}
+	sort.Slice(f.Users, func(i, j int) bool { return f.Users[i].Username < f.Users[j].Username })
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(u.path), ".users")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), u.path)
+}
+
+// Empty returns true if there are no users, authentication is disabled then.
+func (u *Users) Empty() bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return len(u.users) == 0
+}
+
+// Get returns a copy of the user, nil if it does not exist.
+func (u *Users) Get(username string) *User {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	user, ok := u.users[username]
+	if !ok {
+		return nil
+	}
+	c := *user
+	return &c
+}
+
+// SetPassword sets the password of the user, creating it if needed. The first
+// user is an Admin, later ones are Viewers until given another role.
+func (u *Users) SetPassword(username, password string) error {
+	if username == "" {
+		return fmt.Errorf("Username cannot be empty")
+	}
+	if password == "" {
+		return fmt.Errorf("Password cannot be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	user, ok := u.users[username]
+	if !ok {
+		user = &User{Username: username, Role: Viewer}
+		if len(u.users) == 0 {
+			user.Role = Admin
+		}
+		u.users[username] = user
+	}
+	user.PasswordHash = string(hash)
+	return u.saveLocked()
+}
+
+// SetRole sets the role of an existing user.
+func (u *Users) SetRole(username string, role Role) error {
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	user, ok := u.users[username]
+	if !ok {
+		return fmt.Errorf("User %s not found", username)
+	}
+	user.Role = role
+	return u.saveLocked()
+}
+
+// Authenticate returns a copy of the user if the password matches.
+func (u *Users) Authenticate(username, password string) (*User, bool) {
+	user := u.Get(username)
+	hash := dummyHash
+	if user != nil {
+		hash = []byte(user.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || user == nil {
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/HawkMachine/kodi_automation/server/auth"
+)
+
+// parseTrustedProxies parses IP addresses and CIDR ranges of reverse proxies.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("Bad trusted proxy address %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("Bad trusted proxy range %q: %v", p, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (s *MyHTTPServer) isTrustedProxy(ip net.IP) bool {
+	for _, n := range s.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP of the remote address of a request.
+func remoteIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// proxyWrap replaces the remote address with the client address from
+// X-Forwarded-For and marks requests forwarded over HTTPS according to
+// X-Forwarded-Proto for requests from trusted proxies. The client is the
+// last address not of a trusted proxy, addresses before it may be forged.
+func (s *MyHTTPServer) proxyWrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r.RemoteAddr)
+		if ip == nil || !s.isTrustedProxy(ip) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		var forwarded []string
+		for _, v := range r.Header["X-Forwarded-For"] {
+			for _, a := range strings.Split(v, ",") {
+				if a = strings.TrimSpace(a); a != "" {
+					forwarded = append(forwarded, a)
+				}
+			}
+		}
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			fip := net.ParseIP(forwarded[i])
+			if fip == nil {
+				break
+			}
+			r.RemoteAddr = net.JoinHostPort(fip.String(), "0")
+			if !s.isTrustedProxy(fip) {
+				break
+			}
+		}
+		if strings.ToLower(r.Header.Get("X-Forwarded-Proto")) == "https" {
+			r = auth.WithProxyHTTPS(r)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// basePathWriter prefixes redirects to paths on this server with the base
+// path, so that handlers can redirect to paths like "/cron".
+type basePathWriter struct {
+	http.ResponseWriter
+	basePath string
+}
+
+func (bw *basePathWriter) WriteHeader(code int) {
+	if loc := bw.Header().Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+		bw.Header().Set("Location", bw.basePath+loc)
+	}
+	bw.ResponseWriter.WriteHeader(code)
+}
+
+// Flush keeps event streams working through the wrapper.
+func (bw *basePathWriter) Flush() {
+	if f, ok := bw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// basePathWrap serves h under the base path. Handlers see paths without it,
+// session cookies are limited to it.
+func (s *MyHTTPServer) basePathWrap(h http.Handler) http.Handler {
+	if s.basePath == "" {
+		return h
+	}
+	mux := http.NewServeMux()
+	mux.Handle(s.basePath+"/", http.StripPrefix(s.basePath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&basePathWriter{ResponseWriter: w, basePath: s.basePath}, auth.WithBasePath(r, s.basePath))
+	})))
+	mux.Handle(s.basePath, http.RedirectHandler(s.basePath+"/", http.StatusMovedPermanently))
+	return mux
+}
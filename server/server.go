@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -23,6 +25,17 @@ type ViewHandle interface {
 	// Called by server upon receving the request. Returns context used for
 	// rendering the web page or error.
 	ServeHTTP(http.ResponseWriter, *http.Request, HTTPServer)
+
+	// Returns the least role a user needs to use the handler.
+	RequiredRole() auth.Role
+
+	// Returns the scope an API token needs to use the handler, empty if no
+	// scope grants it.
+	RequiredScope() auth.Scope
+
+	// Returns true if the handler changes nothing, API tokens with the read
+	// scope may use it.
+	ReadOnly() bool
 }
 
 // Represents a portion of view logic for some stuff on the server. Usually
@@ -36,7 +49,8 @@ type View interface {
 	// used by ViewHandle with RenderTemplate method of MyHTTPServer.
 	GetTemplates() map[string][]string
 
-	// Returns a mapping from url to ViewHandle that handles that URL.
+	// Returns a mapping from url to ViewHandle that handles that URL. Each
+	// ViewHandle declares the role required to use it.
 	GetHandlers() map[string]ViewHandle
 
 	// Returns a map from name to url. For each an entry will be created in
@@ -44,17 +58,80 @@ type View interface {
 	GetMenu() (string, map[string]string)
 }
 
+// Optionally implemented by a View to show a badge, e.g. a count of unread
+// items, next to its navigation pane entries.
+type MenuBadger interface {
+	// Returns a map from menu entry name to the number shown in its badge.
+	// Entries with zero are shown without a badge.
+	GetMenuBadges() map[string]int
+}
+
+// A single entry in navigation pane.
+type menuEntry struct {
+	URL   string
+	Badge int
+}
+
 // Implementation of ViewHandle that is using a function.
 type viewHandleFunc struct {
-	f func(http.ResponseWriter, *http.Request, HTTPServer)
+	f    func(http.ResponseWriter, *http.Request, HTTPServer)
+	role auth.Role
 }
 
 func (vhf *viewHandleFunc) ServeHTTP(w http.ResponseWriter, r *http.Request, s HTTPServer) {
 	vhf.f(w, r, s)
 }
 
+func (vhf *viewHandleFunc) RequiredRole() auth.Role {
+	return vhf.role
+}
+
+func (vhf *viewHandleFunc) RequiredScope() auth.Scope {
+	return ""
+}
+
+func (vhf *viewHandleFunc) ReadOnly() bool {
+	return false
+}
+
+// NewViewHandle returns a ViewHandle for operators and admins.
 func NewViewHandle(f func(http.ResponseWriter, *http.Request, HTTPServer)) ViewHandle {
-	return &viewHandleFunc{f: f}
+	return NewViewHandleForRole(auth.Operator, f)
+}
+
+// NewViewHandleForRole returns a ViewHandle for users with the role or a
+// higher one.
+func NewViewHandleForRole(role auth.Role, f func(http.ResponseWriter, *http.Request, HTTPServer)) ViewHandle {
+	return &viewHandleFunc{f: f, role: role}
+}
+
+type scopedViewHandle struct {
+	ViewHandle
+	scope auth.Scope
+}
+
+func (svh *scopedViewHandle) RequiredScope() auth.Scope {
+	return svh.scope
+}
+
+// WithScope returns the ViewHandle usable by API tokens with the scope.
+func WithScope(scope auth.Scope, h ViewHandle) ViewHandle {
+	return &scopedViewHandle{ViewHandle: h, scope: scope}
+}
+
+type readOnlyViewHandle struct {
+	ViewHandle
+}
+
+func (rvh *readOnlyViewHandle) ReadOnly() bool {
+	return true
+}
+
+// ReadOnly returns the ViewHandle marked as changing nothing, usable by API
+// tokens with the read scope. Only mark handlers that change nothing for any
+// request method.
+func ReadOnly(h ViewHandle) ViewHandle {
+	return &readOnlyViewHandle{ViewHandle: h}
 }
 
 type basePageContext struct {
@@ -63,14 +140,31 @@ type basePageContext struct {
 	ContentContext interface{}
 	Links          map[string]string
 	IframeLinks    map[string]string
-	ViewsMenu      map[string]map[string]string
+	ViewsMenu      map[string]map[string]menuEntry
+	ShowHome       bool
 	Errors         []string
+	Username       string
+	Role           auth.Role
+	CSRFToken      string
 }
 
 type MyHTTPServer struct {
 	views           map[string]View
 	parsedTemplates map[string]map[string]*template.Template
 
+	// Roles required by registered url patterns.
+	handlerRoles map[string]auth.Role
+
+	mux *http.ServeMux
+
+	// Path prefix the server is reachable under, e.g. "/kodi-helper".
+	basePath string
+
+	trustedProxies []*net.IPNet
+
+	tlsCertFile string
+	tlsKeyFile  string
+
 	port int
 
 	links       map[string]string
@@ -79,8 +173,8 @@ type MyHTTPServer struct {
 	templatesPath string
 	resourcesPath string
 
-	basicAuthUsername string
-	basicAuthPassword string
+	auth          *auth.Auth
+	loginTemplate *template.Template
 
 	templateFuncs template.FuncMap
 }
@@ -99,17 +193,11 @@ func (s *MyHTTPServer) RenderTemplate(w http.ResponseWriter, r *http.Request, vi
 	}
 }
 
-func (s *MyHTTPServer) BasicAuthAuthenticate(username, password string) (bool, error) {
-	log.Printf("AUTH: %s : %s\n", username, password)
-	if s.basicAuthPassword == "" {
-		return true, nil
-	}
-	return s.basicAuthUsername == username && s.basicAuthPassword == password, nil
-}
-
+// logHandleWrap logs requests. Headers, forms and query strings are not
+// logged, they may carry credentials.
 func logHandleWrap(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("REQ : %s : %#v\n", r.RemoteAddr, r)
+		log.Printf("REQ : %s : %s %s\n", r.RemoteAddr, r.Method, r.URL.Path)
 		f(w, r)
 	}
 }
@@ -117,11 +205,78 @@ func logHandleWrap(f func(http.ResponseWriter, *http.Request)) func(http.Respons
 // makeHTTPHandler returns a function that can be used directly to register a
 // handler for a url with http.HandleFunc
 func makeHTTPHandleFunc(s *MyHTTPServer, h ViewHandle) func(http.ResponseWriter, *http.Request) {
-	return logHandleWrap(auth.BasicAuthWrap(s, func(w http.ResponseWriter, r *http.Request) {
+	return logHandleWrap(s.auth.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		if u := auth.UserFromRequest(r); u != nil && !u.Role.Allows(h.RequiredRole()) {
+			s.forbidden(w, r, u, h.RequiredRole())
+			return
+		}
+		if t := auth.TokenFromRequest(r); t != nil && !t.Allows(h.RequiredRole(), h.RequiredScope(), h.ReadOnly()) {
+			auth.Error(w, r, http.StatusForbidden, "forbidden",
+				fmt.Sprintf("Token %s is not allowed to %s %s", t.Name, r.Method, r.URL.Path))
+			return
+		}
 		h.ServeHTTP(w, r, s)
 	}))
 }
 
+// forbidden responds to requests of users without the required role. Users
+// that cannot see the home page are sent to the first page they can see.
+func (s *MyHTTPServer) forbidden(w http.ResponseWriter, r *http.Request, u *auth.User, required auth.Role) {
+	if r.URL.Path == "/" && r.Method == http.MethodGet {
+		if url := s.landingPage(u); url != "" {
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+	}
+	auth.Error(w, r, http.StatusForbidden, "forbidden",
+		fmt.Sprintf("User %s is %s, %s role required", u.Username, u.Role, required))
+}
+
+// roleFor returns the role required for path, matching registered patterns
+// the way http.ServeMux does.
+func (s *MyHTTPServer) roleFor(path string) auth.Role {
+	if role, ok := s.handlerRoles[path]; ok {
+		return role
+	}
+	var best string
+	for pattern := range s.handlerRoles {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return s.handlerRoles[best]
+}
+
+// allowed returns true if the user may use the url. Everything is allowed if
+// authentication is disabled.
+func (s *MyHTTPServer) allowed(u *auth.User, url string) bool {
+	if u == nil {
+		return true
+	}
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	return u.Role.Allows(s.roleFor(url))
+}
+
+// landingPage returns the first menu entry the user may use.
+func (s *MyHTTPServer) landingPage(u *auth.User) string {
+	var urls []string
+	for _, entries := range s.getViewsMenu(u) {
+		for _, e := range entries {
+			urls = append(urls, e.URL)
+		}
+	}
+	sort.Strings(urls)
+	if len(urls) == 0 {
+		return ""
+	}
+	return urls[0]
+}
+
 // RegisterView registers the given View on the server.
 func (s *MyHTTPServer) RegisterView(v View) error {
 	log.Printf("Registering view %q\n", v.GetName())
@@ -147,18 +302,126 @@ func (s *MyHTTPServer) RegisterView(v View) error {
 
 	// Register handlers.
 	for url, h := range v.GetHandlers() {
-		log.Printf("  registering url %q\n", url)
-		http.HandleFunc(url, makeHTTPHandleFunc(s, h))
+		log.Printf("  registering url %q for %s\n", url, h.RequiredRole())
+		s.handlerRoles[url] = h.RequiredRole()
+		s.mux.HandleFunc(url, makeHTTPHandleFunc(s, h))
+	}
+	return nil
+}
+
+// safeRedirect returns next if it is a path on this server, "/" otherwise.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (s *MyHTTPServer) loginHandler(w http.ResponseWriter, r *http.Request) {
+	context := struct {
+		Next     string
+		Username string
+		Error    string
+	}{
+		Next: safeRedirect(r.URL.Query().Get("next")),
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		context.Next = safeRedirect(r.Form.Get("next"))
+		context.Username = r.Form.Get("username")
+		ok, err := s.auth.Login(w, r, context.Username, r.Form.Get("password"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			log.Printf("User %s logged in from %s\n", context.Username, r.RemoteAddr)
+			http.Redirect(w, r, context.Next, http.StatusFound)
+			return
+		}
+		log.Printf("Failed login of %q from %s\n", context.Username, r.RemoteAddr)
+		context.Error = "Wrong username or password"
+		w.WriteHeader(http.StatusUnauthorized)
+	default:
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.loginTemplate.ExecuteTemplate(w, "login", context); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *MyHTTPServer) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
 	}
+	s.auth.Logout(w, r)
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// SetBasePath makes the server reachable under the path, e.g.
+// "/kodi-helper/" when it runs behind a reverse proxy next to other apps.
+func (s *MyHTTPServer) SetBasePath(basePath string) error {
+	basePath = strings.TrimRight(basePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		return fmt.Errorf("Base path %q must start with /", basePath)
+	}
+	s.basePath = basePath
+	return nil
+}
+
+// SetTrustedProxies sets addresses and CIDR ranges of reverse proxies whose
+// X-Forwarded-For and X-Forwarded-Proto headers are used.
+func (s *MyHTTPServer) SetTrustedProxies(proxies []string) error {
+	nets, err := parseTrustedProxies(proxies)
+	if err != nil {
+		return err
+	}
+	s.trustedProxies = nets
+	return nil
+}
+
+// SetTLS makes the server use HTTPS with the certificate and key files. With
+// selfSigned a certificate for the hosts is generated if the files do not
+// exist.
+func (s *MyHTTPServer) SetTLS(certFile, keyFile string, selfSigned bool, hosts []string) error {
+	if certFile == "" || keyFile == "" {
+		return fmt.Errorf("TLS needs both certificate and key file")
+	}
+	if selfSigned {
+		if err := generateSelfSignedCert(certFile, keyFile, hosts); err != nil {
+			return err
+		}
+	}
+	s.tlsCertFile = certFile
+	s.tlsKeyFile = keyFile
 	return nil
 }
 
 func (s *MyHTTPServer) Run() {
-	http.Handle("/resources/", http.StripPrefix("/resources/", http.FileServer(http.Dir(s.resourcesPath))))
+	s.mux.Handle("/resources/", http.StripPrefix("/resources/", http.FileServer(http.Dir(s.resourcesPath))))
+
+	s.loginTemplate = template.Must(template.New("login.html").Funcs(s.templateFuncs).ParseFiles(filepath.Join(s.templatesPath, "login.html")))
+	s.mux.HandleFunc("/login", logHandleWrap(s.loginHandler))
+	s.mux.HandleFunc("/logout", logHandleWrap(s.auth.Wrap(s.logoutHandler)))
 
 	bindAddr := fmt.Sprintf(":%d", s.port)
-	log.Printf("Bind address %s", bindAddr)
-	log.Fatal(http.ListenAndServe(bindAddr, nil))
+	srv := &http.Server{
+		Addr:    bindAddr,
+		Handler: s.proxyWrap(s.basePathWrap(s.mux)),
+	}
+	if s.tlsCertFile != "" {
+		log.Printf("Bind address %s (TLS), base path %q", bindAddr, s.basePath+"/")
+		log.Fatal(srv.ListenAndServeTLS(s.tlsCertFile, s.tlsKeyFile))
+	}
+	log.Printf("Bind address %s, base path %q", bindAddr, s.basePath+"/")
+	log.Fatal(srv.ListenAndServe())
 }
 
 func (s *MyHTTPServer) getTemplate(viewName, templateName string) (*template.Template, error) {
@@ -175,20 +438,47 @@ func (s *MyHTTPServer) getTemplate(viewName, templateName string) (*template.Tem
 }
 
 func (s *MyHTTPServer) getBaseContext(r *http.Request) basePageContext {
-	return basePageContext{
+	u := auth.UserFromRequest(r)
+	c := basePageContext{
 		IsMobile:    s.isMobile(r),
 		Links:       s.links,
-		IframeLinks: s.iframeLinks,
-		ViewsMenu:   s.getViewsMenu(),
+		IframeLinks: map[string]string{},
+		ViewsMenu:   s.getViewsMenu(u),
+		ShowHome:    s.allowed(u, "/"),
+		CSRFToken:   auth.CSRFToken(r),
+	}
+	for name, url := range s.iframeLinks {
+		if s.allowed(u, "/wrap/"+name) {
+			c.IframeLinks[name] = url
+		}
+	}
+	if u != nil {
+		c.Username = u.Username
+		c.Role = u.Role
 	}
+	return c
 }
 
-func (s *MyHTTPServer) getViewsMenu() map[string]map[string]string {
-	menu := map[string]map[string]string{}
+// getViewsMenu returns the navigation pane entries the user may use.
+func (s *MyHTTPServer) getViewsMenu(u *auth.User) map[string]map[string]menuEntry {
+	menu := map[string]map[string]menuEntry{}
 	for _, v := range s.views {
 		title, vmenu := v.GetMenu()
-		if len(vmenu) != 0 {
-			menu[title] = vmenu
+		if len(vmenu) == 0 {
+			continue
+		}
+		var badges map[string]int
+		if mb, ok := v.(MenuBadger); ok {
+			badges = mb.GetMenuBadges()
+		}
+		entries := map[string]menuEntry{}
+		for name, url := range vmenu {
+			if s.allowed(u, url) {
+				entries[name] = menuEntry{URL: url, Badge: badges[name]}
+			}
+		}
+		if len(entries) != 0 {
+			menu[title] = entries
 		}
 	}
 	log.Printf("Menu: %v\n", menu)
@@ -201,7 +491,7 @@ func (s *MyHTTPServer) isMobile(r *http.Request) bool {
 }
 
 // New creates new instance of MyHTTPServer.
-func NewMyHTTPServer(port int, basicAuthUsername string, basicAuthPassword string,
+func NewMyHTTPServer(port int, a *auth.Auth,
 	templatesPath string, resourcesPath string,
 	links map[string]string, iframeLinks map[string]string) *MyHTTPServer {
 
@@ -214,6 +504,8 @@ func NewMyHTTPServer(port int, basicAuthUsername string, basicAuthPassword strin
 	httpServer := &MyHTTPServer{
 		views:           map[string]View{},
 		parsedTemplates: map[string]map[string]*template.Template{},
+		handlerRoles:    map[string]auth.Role{},
+		mux:             http.NewServeMux(),
 
 		port:        port,
 		links:       links,
@@ -222,8 +514,7 @@ func NewMyHTTPServer(port int, basicAuthUsername string, basicAuthPassword strin
 		templatesPath: templatesPath,
 		resourcesPath: resourcesPath,
 
-		basicAuthUsername: basicAuthUsername,
-		basicAuthPassword: basicAuthPassword,
+		auth: a,
 
 		templateFuncs: template.FuncMap{
 			"timeformat": func(v time.Time, f string) string {
@@ -244,5 +535,9 @@ func NewMyHTTPServer(port int, basicAuthUsername string, basicAuthPassword strin
 			},
 		},
 	}
+	// Paths in templates are relative to the base path.
+	httpServer.templateFuncs["url"] = func(path string) string {
+		return httpServer.basePath + path
+	}
 	return httpServer
 }
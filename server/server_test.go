@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HawkMachine/kodi_automation/server/auth"
+)
+
+// testView registers handlers the way the move server view does.
+type testView struct {
+	called map[string]bool
+}
+
+func (tv *testView) GetName() string                      { return "test" }
+func (tv *testView) GetTemplates() map[string][]string    { return nil }
+func (tv *testView) GetMenu() (string, map[string]string) { return "", nil }
+
+func (tv *testView) handler(name string) func(http.ResponseWriter, *http.Request, HTTPServer) {
+	return func(w http.ResponseWriter, r *http.Request, s HTTPServer) {
+		tv.called[name] = true
+	}
+}
+
+func (tv *testView) GetHandlers() map[string]ViewHandle {
+	return map[string]ViewHandle{
+		"/":      ReadOnly(NewViewHandle(tv.handler("/"))),
+		"/move":  WithScope(auth.ScopeMove, NewViewHandle(tv.handler("/move"))),
+		"/admin": NewViewHandleForRole(auth.Admin, tv.handler("/admin")),
+	}
+}
+
+func newTestServer(t *testing.T) (*MyHTTPServer, *auth.Tokens, *testView) {
+	users, err := auth.LoadUsers("")
+	if err != nil {
+		t.Fatalf("LoadUsers() failed: %v", err)
+	}
+	if err := users.SetPassword("admin", "secret"); err != nil {
+		t.Fatalf("SetPassword() failed: %v", err)
+	}
+	tokens, err := auth.LoadTokens("")
+	if err != nil {
+		t.Fatalf("LoadTokens() failed: %v", err)
+	}
+	s := NewMyHTTPServer(0, auth.New(users, tokens, time.Hour), "", "", nil, nil)
+	tv := &testView{called: map[string]bool{}}
+	if err := s.RegisterView(tv); err != nil {
+		t.Fatalf("RegisterView() failed: %v", err)
+	}
+	return s, tokens, tv
+}
+
+func TestTokenScopes(t *testing.T) {
+	s, tokens, tv := newTestServer(t)
+	reader, err := tokens.Create("reader", []auth.Scope{auth.ScopeRead}, "admin")
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	mover, err := tokens.Create("mover", []auth.Scope{auth.ScopeMove}, "admin")
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		token  string
+		method string
+		path   string
+		want   int
+	}{
+		{reader, http.MethodGet, "/", http.StatusOK},
+		// The method does not make a handler read-only.
+		{reader, http.MethodGet, "/move?name=x", http.StatusForbidden},
+		{reader, http.MethodPost, "/move", http.StatusForbidden},
+		{mover, http.MethodPost, "/move", http.StatusOK},
+		{mover, http.MethodGet, "/", http.StatusForbidden},
+		{mover, http.MethodGet, "/admin", http.StatusForbidden},
+	} {
+		tv.called = map[string]bool{}
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+		if called := len(tv.called) != 0; called != (tc.want == http.StatusOK) {
+			t.Errorf("%s %s called the handler: %v", tc.method, tc.path, called)
+		}
+	}
+}
+
+func TestSessionCookiePath(t *testing.T) {
+	for _, tc := range []struct {
+		basePath string
+		want     string
+	}{
+		{"", "/"},
+		{"/kodi/", "/kodi"},
+	} {
+		s, _, _ := newTestServer(t)
+		if err := s.SetBasePath(tc.basePath); err != nil {
+			t.Fatalf("SetBasePath(%q) failed: %v", tc.basePath, err)
+		}
+		s.mux.HandleFunc("/login", s.loginHandler)
+
+		form := url.Values{"username": {"admin"}, "password": {"secret"}}
+		r := httptest.NewRequest(http.MethodPost, s.basePath+"/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.basePathWrap(s.mux).ServeHTTP(w, r)
+		cookies := w.Result().Cookies()
+		if w.Code != http.StatusFound || len(cookies) != 1 {
+			t.Fatalf("Login under %q = %d with cookies %v, want a redirect with the session cookie", tc.basePath, w.Code, cookies)
+		}
+		if cookies[0].Path != tc.want {
+			t.Errorf("Session cookie path under %q = %q, want %q", tc.basePath, cookies[0].Path, tc.want)
+		}
+	}
+}
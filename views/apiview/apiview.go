@@ -0,0 +1,359 @@
+package apiview
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/HawkMachine/kodi_automation/moveserver"
+	"github.com/HawkMachine/kodi_automation/platform"
+	"github.com/HawkMachine/kodi_automation/platform/cron"
+	"github.com/HawkMachine/kodi_automation/server"
+	"github.com/HawkMachine/kodi_automation/server/auth"
+	"github.com/HawkMachine/transmission_go_api"
+)
+
+// APIView serves the JSON API under /api/v1/. All responses are JSON, errors
+// are returned as {"error": {"code": ..., "message": ...}}.
+type APIView struct {
+	p          *platform.Platform
+	moveServer *moveserver.MoveServer
+	tr         *transmission_go_api.Transmission
+}
+
+func (av *APIView) GetName() string {
+	return "apiview"
+}
+
+func (av *APIView) GetTemplates() map[string][]string {
+	return map[string][]string{}
+}
+
+func (av *APIView) GetHandlers() map[string]server.ViewHandle {
+	return map[string]server.ViewHandle{
+		"/api/v1/":               server.ReadOnly(server.NewViewHandleForRole(auth.Viewer, av.notFoundHandler)),
+		"/api/v1/paths":          server.ReadOnly(server.NewViewHandle(av.pathsHandler)),
+		"/api/v1/paths/movepath": server.WithScope(auth.ScopeMove, server.NewViewHandle(av.setMovePathHandler)),
+		"/api/v1/paths/priority": server.WithScope(auth.ScopeTransmission, server.NewViewHandle(av.setPriorityHandler)),
+		"/api/v1/paths/move":     server.WithScope(auth.ScopeMove, server.NewViewHandle(av.moveHandler)),
+		"/api/v1/history":        server.ReadOnly(server.NewViewHandle(av.historyHandler)),
+		"/api/v1/disks":          server.ReadOnly(server.NewViewHandle(av.disksHandler)),
+		"/api/v1/messages":       server.ReadOnly(server.NewViewHandle(av.messagesHandler)),
+		"/api/v1/assistant":      server.WithScope(auth.ScopeMove, server.NewViewHandle(av.assistantHandler)),
+		"/api/v1/cron":           server.ReadOnly(server.NewViewHandle(av.cronHandler)),
+		"/api/v1/cron/run":       server.NewViewHandleForRole(auth.Admin, av.cronRunHandler),
+		"/api/v1/cron/enable":    server.NewViewHandleForRole(auth.Admin, av.cronEnableHandler),
+		"/api/v1/cron/disable":   server.NewViewHandleForRole(auth.Admin, av.cronDisableHandler),
+		"/api/v1/torrents":       server.ReadOnly(server.NewViewHandle(av.torrentsHandler)),
+	}
+}
+
+// The API has no entries in navigation pane.
+func (av *APIView) GetMenu() (string, map[string]string) {
+	return "API", map[string]string{}
+}
+
+func New(p *platform.Platform, moveServer *moveserver.MoveServer) *APIView {
+	t, _ := transmission_go_api.New(
+		p.Config.Transmission.Address,
+		p.Config.Transmission.Username,
+		p.Config.Transmission.Password,
+	)
+	return &APIView{
+		p:          p,
+		moveServer: moveServer,
+		tr:         t,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Writing API response failed: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string, err error) {
+	writeJSON(w, status, errorResponse{Error: apiError{Code: code, Message: err.Error()}})
+}
+
+// allowMethod writes an error and returns false if the request method is not
+// the given one.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed",
+			fmt.Errorf("Method %s not allowed, use %s", r.Method, method))
+		return false
+	}
+	return true
+}
+
+// decodeBody decodes the JSON request body into v. It writes an error and
+// returns false on failure.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Errorf("Invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
+
+// pathExists writes an error and returns false if there is no path info with
+// the name.
+func (av *APIView) pathExists(w http.ResponseWriter, name string) bool {
+	pathInfo, _ := av.moveServer.GetPathInfoAndPathInfoHistory()
+	if _, ok := pathInfo[name]; !ok {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Errorf("Item %s not found", name))
+		return false
+	}
+	return true
+}
+
+func (av *APIView) notFoundHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	writeError(w, http.StatusNotFound, "not_found", fmt.Errorf("No API endpoint %s", r.URL.Path))
+}
+
+func (av *APIView) pathsHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	pathInfo, _ := av.moveServer.GetPathInfoAndPathInfoHistory()
+	paths := []pathInfoJSON{}
+	for _, pi := range pathInfo {
+		paths = append(paths, newPathInfoJSON(pi))
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Name < paths[j].Name })
+	writeJSON(w, http.StatusOK, paths)
+}
+
+func (av *APIView) setMovePathHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Name   string `json:"name"`
+		MoveTo string `json:"move_to"`
+	}
+	if !decodeBody(w, r, &req) || !av.pathExists(w, req.Name) {
+		return
+	}
+	if err := av.moveServer.SetMovePath(req.Name, req.MoveTo); err != nil {
+		writeError(w, http.StatusBadRequest, "set_move_path_failed", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, okResponse{OK: true})
+}
+
+func (av *APIView) setPriorityHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Name     string `json:"name"`
+		Priority int    `json:"priority"`
+	}
+	if !decodeBody(w, r, &req) || !av.pathExists(w, req.Name) {
+		return
+	}
+	if err := av.moveServer.SetPriority(req.Name, req.Priority); err != nil {
+		writeError(w, http.StatusBadRequest, "set_priority_failed", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, okResponse{OK: true})
+}
+
+func (av *APIView) moveHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decodeBody(w, r, &req) || !av.pathExists(w, req.Name) {
+		return
+	}
+	if err := av.moveServer.Move(req.Name); err != nil {
+		writeError(w, http.StatusConflict, "move_failed", err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, okResponse{OK: true})
+}
+
+func (av *APIView) historyHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	_, pathInfoHistory := av.moveServer.GetPathInfoAndPathInfoHistory()
+	history := []pathInfoJSON{}
+	for _, pi := range pathInfoHistory {
+		history = append(history, newPathInfoJSON(pi))
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (av *APIView) disksHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	disks := []diskJSON{}
+	for _, ds := range av.moveServer.GetDiskStats() {
+		disks = append(disks, newDiskJSON(ds))
+	}
+	writeJSON(w, http.StatusOK, disks)
+}
+
+func (av *APIView) messagesHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	messages := []messageJSON{}
+	for _, m := range av.moveServer.GetMessages() {
+		messages = append(messages, messageJSON{T: m.T, Type: m.Type, Msg: m.Msg})
+	}
+	writeJSON(w, http.StatusOK, messages)
+}
+
+func (av *APIView) assistantStatus() assistantJSON {
+	a := av.moveServer.Assistant
+	status := assistantJSON{
+		Enabled:        a.IsEnabled(),
+		DryRun:         a.IsDryRun(),
+		Config:         a.Config(),
+		Schedule:       a.Schedule(),
+		NeedsAttention: a.NeedsAttention(),
+	}
+	if run := a.LastRun(); run != nil {
+		rj := &assistantRunJSON{
+			T:         run.T,
+			DryRun:    run.DryRun,
+			Decisions: run.Decisions,
+		}
+		for _, ts := range run.TorrentStatus {
+			rj.TorrentStatus = append(rj.TorrentStatus, torrentStatusJSON{
+				Name:             ts.Name,
+				Priority:         ts.Priority,
+				Status:           ts.Status,
+				StartStatus:      ts.StartStatus,
+				MoveStatus:       ts.MoveStatus,
+				NotStartedReason: ts.NotStartedReason,
+				NotMovedReason:   ts.NotMovedReason,
+				Health:           ts.Health,
+			})
+		}
+		sort.Slice(rj.TorrentStatus, func(i, j int) bool { return rj.TorrentStatus[i].Name < rj.TorrentStatus[j].Name })
+		status.LastRun = rj
+	}
+	return status
+}
+
+// assistantHandler returns the Assistant status on GET. POST turns the
+// Assistant or its dry run mode on or off, fields left out are not changed.
+func (av *APIView) assistantHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Enabled *bool `json:"enabled"`
+			DryRun  *bool `json:"dry_run"`
+		}
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		a := av.moveServer.Assistant
+		if req.Enabled != nil {
+			if *req.Enabled {
+				a.Enable()
+			} else {
+				a.Disable()
+			}
+		}
+		if req.DryRun != nil {
+			a.SetDryRun(*req.DryRun)
+		}
+	default:
+		allowMethod(w, r, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, av.assistantStatus())
+}
+
+func (av *APIView) cronHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	jobs := []cronJobJSON{}
+	for _, j := range av.p.Cron.CronJobs() {
+		jobs = append(jobs, newCronJobJSON(j))
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// cronJobRequest handles a POST naming a cron job. It calls f with the job
+// and writes the job or the error f returned.
+func (av *APIView) cronJobRequest(w http.ResponseWriter, r *http.Request, f func(*cron.CronJob) error) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	j, err := av.p.Cron.Job(req.Name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not_found", err)
+		return
+	}
+	if err := f(j); err != nil {
+		writeError(w, http.StatusConflict, "conflict", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newCronJobJSON(j))
+}
+
+func (av *APIView) cronRunHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	av.cronJobRequest(w, r, func(j *cron.CronJob) error {
+		if j.IsRunning() {
+			return fmt.Errorf("Cron job %s is already running", j.Name)
+		}
+		go j.RunNow()
+		return nil
+	})
+}
+
+func (av *APIView) cronEnableHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	av.cronJobRequest(w, r, func(j *cron.CronJob) error {
+		j.Enable()
+		return nil
+	})
+}
+
+func (av *APIView) cronDisableHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	av.cronJobRequest(w, r, func(j *cron.CronJob) error {
+		j.Disable()
+		return nil
+	})
+}
+
+func (av *APIView) torrentsHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	torrents, err := av.tr.ListAll()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "transmission_error", err)
+		return
+	}
+	if torrents == nil {
+		torrents = []*transmission_go_api.Torrent{}
+	}
+	writeJSON(w, http.StatusOK, torrents)
+}
@@ -0,0 +1,195 @@
+package apiview
+
+import (
+	"time"
+
+	"github.com/HawkMachine/kodi_automation/moveserver"
+	"github.com/HawkMachine/kodi_automation/platform/cron"
+	"github.com/HawkMachine/transmission_go_api"
+)
+
+// Types below are the JSON representation of moveserver and cron state. They
+// turn errors into strings and give the API stable snake_case names.
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type okResponse struct {
+	OK bool `json:"ok"`
+}
+
+type moveInfoJSON struct {
+	Moving          bool   `json:"moving"`
+	Target          string `json:"target,omitempty"`
+	Mode            string `json:"mode,omitempty"`
+	Status          string `json:"status,omitempty"`
+	LastError       string `json:"last_error,omitempty"`
+	LastErrorOutput string `json:"last_error_output,omitempty"`
+}
+
+type pathInfoJSON struct {
+	Name           string                       `json:"name"`
+	Path           string                       `json:"path"`
+	MoveTo         string                       `json:"move_to"`
+	AllowMove      bool                         `json:"allow_move"`
+	AllowAssistant bool                         `json:"allow_assistant"`
+	Imported       bool                         `json:"imported"`
+	Priority       int                          `json:"priority"`
+	Move           moveInfoJSON                 `json:"move"`
+	Torrent        *transmission_go_api.Torrent `json:"torrent,omitempty"`
+}
+
+func newPathInfoJSON(pi *moveserver.PathInfo) pathInfoJSON {
+	mi := moveInfoJSON{
+		Moving:          pi.MoveInfo.Moving,
+		Target:          pi.MoveInfo.Target,
+		Mode:            string(pi.MoveInfo.Mode),
+		Status:          pi.MoveInfo.Status,
+		LastErrorOutput: pi.MoveInfo.LastErrorOutput,
+	}
+	if pi.MoveInfo.LastError != nil {
+		mi.LastError = pi.MoveInfo.LastError.Error()
+	}
+	return pathInfoJSON{
+		Name:           pi.Name,
+		Path:           pi.Path,
+		MoveTo:         pi.MoveTo,
+		AllowMove:      pi.AllowMove,
+		AllowAssistant: pi.AllowAssistant,
+		Imported:       pi.Imported,
+		Priority:       pi.Priority,
+		Move:           mi,
+		Torrent:        pi.Torrent,
+	}
+}
+
+type diskJSON struct {
+	Path              string `json:"path"`
+	FsType            string `json:"fs_type"`
+	Size              int64  `json:"size"`
+	Used              int64  `json:"used"`
+	Avail             int64  `json:"avail"`
+	PercentFull       int    `json:"percent_full"`
+	Inodes            int64  `json:"inodes"`
+	InodesFree        int64  `json:"inodes_free"`
+	PercentInodesFull int    `json:"percent_inodes_full"`
+	Threshold         int    `json:"threshold"`
+	OverThreshold     bool   `json:"over_threshold"`
+	FillRate          int64  `json:"fill_rate_per_day"`
+	FullInSeconds     int64  `json:"full_in_seconds,omitempty"`
+}
+
+func newDiskJSON(ds moveserver.DiskStats) diskJSON {
+	return diskJSON{
+		Path:              ds.Path,
+		FsType:            ds.FsType,
+		Size:              ds.Size,
+		Used:              ds.Used,
+		Avail:             ds.Avail,
+		PercentFull:       ds.PercentFull,
+		Inodes:            ds.Inodes,
+		InodesFree:        ds.InodesFree,
+		PercentInodesFull: ds.PercentInodesFull,
+		Threshold:         ds.Threshold,
+		OverThreshold:     ds.OverThreshold(),
+		FillRate:          ds.FillRate,
+		FullInSeconds:     int64(ds.FullIn / time.Second),
+	}
+}
+
+type messageJSON struct {
+	T    time.Time `json:"t"`
+	Type string    `json:"type"`
+	Msg  string    `json:"msg"`
+}
+
+type torrentStatusJSON struct {
+	Name             string `json:"name"`
+	Priority         int    `json:"priority"`
+	Status           string `json:"status"`
+	StartStatus      string `json:"start_status"`
+	MoveStatus       string `json:"move_status"`
+	NotStartedReason string `json:"not_started_reason,omitempty"`
+	NotMovedReason   string `json:"not_moved_reason,omitempty"`
+	Health           string `json:"health,omitempty"`
+}
+
+type assistantRunJSON struct {
+	T             time.Time           `json:"t"`
+	DryRun        bool                `json:"dry_run"`
+	Decisions     []string            `json:"decisions"`
+	TorrentStatus []torrentStatusJSON `json:"torrent_status"`
+}
+
+type assistantJSON struct {
+	Enabled        bool                        `json:"enabled"`
+	DryRun         bool                        `json:"dry_run"`
+	Config         moveserver.AssistantConfig  `json:"config"`
+	Schedule       []moveserver.ScheduleWindow `json:"schedule"`
+	NeedsAttention map[string]string           `json:"needs_attention"`
+	LastRun        *assistantRunJSON           `json:"last_run,omitempty"`
+}
+
+type cronStatsJSON struct {
+	Runs                int     `json:"runs"`
+	Failures            int     `json:"failures"`
+	Skipped             int     `json:"skipped"`
+	SuccessRate         float64 `json:"success_rate"`
+	P50Seconds          float64 `json:"p50_seconds"`
+	P95Seconds          float64 `json:"p95_seconds"`
+	LastError           string  `json:"last_error,omitempty"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+}
+
+type cronJobJSON struct {
+	Name     string          `json:"name"`
+	Schedule string          `json:"schedule"`
+	Enabled  bool            `json:"enabled"`
+	Running  bool            `json:"running"`
+	NextRun  time.Time       `json:"next_run"`
+	Stats    cronStatsJSON   `json:"stats"`
+	LastRuns []*cron.RunInfo `json:"last_runs"`
+
+	// Timed out runs whose function has not returned yet.
+	AbandonedRuns int `json:"abandoned_runs"`
+}
+
+// Number of runs included with each cron job.
+const cronLastRuns = 10
+
+func newCronJobJSON(j *cron.CronJob) cronJobJSON {
+	st := j.Stats()
+	stats := cronStatsJSON{
+		Runs:                st.Runs,
+		Failures:            st.Failures,
+		Skipped:             st.Skipped,
+		SuccessRate:         st.SuccessRate,
+		P50Seconds:          st.P50.Seconds(),
+		P95Seconds:          st.P95.Seconds(),
+		ConsecutiveFailures: st.ConsecutiveFailures,
+	}
+	if st.LastError != nil {
+		stats.LastError = st.LastError.Error()
+	}
+	runs := j.History()
+	if len(runs) > cronLastRuns {
+		runs = runs[:cronLastRuns]
+	}
+	return cronJobJSON{
+		Name:     j.Name,
+		Schedule: j.GetSchedule().String(),
+		Enabled:  j.IsEnabled(),
+		Running:  j.IsRunning(),
+		NextRun:  j.GetNextRun(),
+		Stats:    stats,
+		LastRuns: runs,
+
+		AbandonedRuns: j.AbandonedRuns(),
+	}
+}
@@ -1,11 +1,14 @@
 package cronview
 
 import (
+	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/HawkMachine/kodi_automation/platform"
 	"github.com/HawkMachine/kodi_automation/platform/cron"
 	"github.com/HawkMachine/kodi_automation/server"
+	"github.com/HawkMachine/kodi_automation/server/auth"
 )
 
 type CronView struct {
@@ -27,7 +30,11 @@ func (cv *CronView) GetTemplates() map[string][]string {
 
 func (cv *CronView) GetHandlers() map[string]server.ViewHandle {
 	return map[string]server.ViewHandle{
-		"/cron": server.NewViewHandle(cv.cronPage),
+		"/cron":          server.ReadOnly(server.NewViewHandle(cv.cronPage)),
+		"/cron/run":      server.NewViewHandleForRole(auth.Admin, cv.runPostHandler),
+		"/cron/enable":   server.NewViewHandleForRole(auth.Admin, cv.enablePostHandler),
+		"/cron/disable":  server.NewViewHandleForRole(auth.Admin, cv.disablePostHandler),
+		"/cron/schedule": server.NewViewHandleForRole(auth.Admin, cv.schedulePostHandler),
 	}
 }
 
@@ -43,9 +50,74 @@ func New(server server.HTTPServer, p *platform.Platform) server.View {
 
 func (cv *CronView) cronPage(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
 	context := struct {
-		CronJobs map[string]*cron.CronJob
+		CronJobs   map[string]*cron.CronJob
+		CanControl bool
 	}{
-		CronJobs: cv.p.Cron.CronJobs(),
+		CronJobs:   cv.p.Cron.CronJobs(),
+		CanControl: auth.Allowed(r, auth.Admin),
 	}
 	s.RenderTemplate(w, r, cv.GetName(), "cron_page", "Cron", context)
 }
+
+// postJob parses the POST form and returns the job named in it. It writes an
+// error to w and returns nil on failure.
+func (cv *CronView) postJob(w http.ResponseWriter, r *http.Request) *cron.CronJob {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return nil
+	}
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	j, err := cv.p.Cron.Job(r.Form.Get("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+	return j
+}
+
+func (cv *CronView) runPostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	j := cv.postJob(w, r)
+	if j == nil {
+		return
+	}
+	log.Printf("Running cron job %s now", j.Name)
+	go j.RunNow()
+	http.Redirect(w, r, "/cron", http.StatusFound)
+}
+
+func (cv *CronView) enablePostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	j := cv.postJob(w, r)
+	if j == nil {
+		return
+	}
+	j.Enable()
+	http.Redirect(w, r, "/cron", http.StatusFound)
+}
+
+func (cv *CronView) disablePostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	j := cv.postJob(w, r)
+	if j == nil {
+		return
+	}
+	j.Disable()
+	http.Redirect(w, r, "/cron", http.StatusFound)
+}
+
+func (cv *CronView) schedulePostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	j := cv.postJob(w, r)
+	if j == nil {
+		return
+	}
+	schedule, err := cron.Parse(r.Form.Get("schedule"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Changing schedule of cron job %s to %s", j.Name, schedule)
+	j.SetSchedule(schedule)
+	http.Redirect(w, r, "/cron", http.StatusFound)
+}
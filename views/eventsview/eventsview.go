@@ -0,0 +1,102 @@
+package eventsview
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/HawkMachine/kodi_automation/platform"
+	"github.com/HawkMachine/kodi_automation/platform/events"
+	"github.com/HawkMachine/kodi_automation/server"
+)
+
+const (
+	// Events buffered per client, more are dropped if the client is slow.
+	clientBuffer = 256
+
+	// Comments sent to keep idle connections open through proxies.
+	keepAliveInterval = 30 * time.Second
+)
+
+// EventsView streams events of the platform event bus as server-sent events.
+type EventsView struct {
+	p *platform.Platform
+}
+
+func (ev *EventsView) GetName() string {
+	return "eventsview"
+}
+
+func (ev *EventsView) GetTemplates() map[string][]string {
+	return map[string][]string{}
+}
+
+func (ev *EventsView) GetHandlers() map[string]server.ViewHandle {
+	return map[string]server.ViewHandle{
+		"/events": server.ReadOnly(server.NewViewHandle(ev.eventsHandler)),
+	}
+}
+
+func (ev *EventsView) GetMenu() (string, map[string]string) {
+	return "Events", map[string]string{}
+}
+
+func New(p *platform.Platform) server.View {
+	return &EventsView{p: p}
+}
+
+// eventsHandler streams events until the client disconnects. The optional
+// types parameter is a comma separated list of event types to send.
+func (ev *EventsView) eventsHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	types := map[events.Type]bool{}
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if t != "" {
+			types[events.Type(t)] = true
+		}
+	}
+
+	sub := ev.p.Events.Subscribe(clientBuffer)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	// Clients reconnect after 5 seconds if the stream breaks.
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if len(types) != 0 && !types[e.Type] {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("Encoding event %d failed: %v\n", e.ID, err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
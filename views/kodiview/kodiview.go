@@ -10,6 +10,7 @@ import (
 
 	"github.com/HawkMachine/kodi_automation/platform"
 	"github.com/HawkMachine/kodi_automation/server"
+	"github.com/HawkMachine/kodi_automation/server/auth"
 	"github.com/HawkMachine/kodi_go_api/v6/kodi"
 )
 
@@ -65,11 +66,11 @@ func (ksv *KodiView) GetTemplates() map[string][]string {
 
 func (ksv *KodiView) GetHandlers() map[string]server.ViewHandle {
 	return map[string]server.ViewHandle{
-		"/kodi/stats":            server.NewViewHandle(ksv.kodiStatsPageHandler),
-		"/kodi/stats/_getdata/":  server.NewViewHandle(ksv.kodiStatsGetDataHandler),
-		"/kodi/health":           server.NewViewHandle(ksv.kodiHealthPageHandler),
-		"/kodi/library/movies":   server.NewViewHandle(ksv.kodiLibraryMoviesPageHandler),
-		"/kodi/library/tv_shows": server.NewViewHandle(ksv.kodiLibraryTVShowsPageHandler),
+		"/kodi/stats":            server.ReadOnly(server.WithScope(auth.ScopeKodiControl, server.NewViewHandleForRole(auth.Viewer, ksv.kodiStatsPageHandler))),
+		"/kodi/stats/_getdata/":  server.ReadOnly(server.WithScope(auth.ScopeKodiControl, server.NewViewHandleForRole(auth.Viewer, ksv.kodiStatsGetDataHandler))),
+		"/kodi/health":           server.ReadOnly(server.WithScope(auth.ScopeKodiControl, server.NewViewHandle(ksv.kodiHealthPageHandler))),
+		"/kodi/library/movies":   server.ReadOnly(server.WithScope(auth.ScopeKodiControl, server.NewViewHandleForRole(auth.Viewer, ksv.kodiLibraryMoviesPageHandler))),
+		"/kodi/library/tv_shows": server.ReadOnly(server.WithScope(auth.ScopeKodiControl, server.NewViewHandleForRole(auth.Viewer, ksv.kodiLibraryTVShowsPageHandler))),
 	}
 }
 
@@ -5,9 +5,12 @@ import (
 	"log"
 	"net/http"
 	"sort"
+	"strconv"
+	"time"
 
 	"github.com/HawkMachine/kodi_automation/moveserver"
 	"github.com/HawkMachine/kodi_automation/server"
+	"github.com/HawkMachine/kodi_automation/server/auth"
 )
 
 // PathInfoSlice is sortable list of moveserver.PathInfo.
@@ -44,17 +47,27 @@ func (msv *MoveServerView) GetTemplates() map[string][]string {
 			"base.html",
 			"assistant.html",
 		},
+		"assistant_item_page": []string{
+			"base.html",
+			"assistant_item.html",
+		},
 	}
 }
 
 func (msv *MoveServerView) GetHandlers() map[string]server.ViewHandle {
 	return map[string]server.ViewHandle{
-		"/":             server.NewViewHandle(msv.moveDashboardPageHandler),
-		"/move":         server.NewViewHandle(msv.movePostHandler),
-		"/setmovepath":  server.NewViewHandle(msv.setMovePathPostHandler),
-		"/update/cache": server.NewViewHandle(msv.updateCacheHandler),
-		"/update/disks": server.NewViewHandle(msv.updateDiskStatsHandler),
-		"/assistant":    server.NewViewHandle(msv.assistantHandler),
+		"/":             server.ReadOnly(server.NewViewHandle(msv.moveDashboardPageHandler)),
+		"/move":         server.WithScope(auth.ScopeMove, server.NewViewHandle(msv.movePostHandler)),
+		"/setmovepath":  server.WithScope(auth.ScopeMove, server.NewViewHandle(msv.setMovePathPostHandler)),
+		"/setpriority":  server.WithScope(auth.ScopeTransmission, server.NewViewHandle(msv.setPriorityPostHandler)),
+		"/update/cache": server.WithScope(auth.ScopeMove, server.NewViewHandle(msv.updateCacheHandler)),
+		"/update/disks": server.WithScope(auth.ScopeMove, server.NewViewHandle(msv.updateDiskStatsHandler)),
+		"/assistant":    server.ReadOnly(server.WithScope(auth.ScopeMove, server.NewViewHandle(msv.assistantHandler))),
+
+		"/assistant/dryrun":   server.WithScope(auth.ScopeMove, server.NewViewHandle(msv.assistantDryRunPostHandler)),
+		"/assistant/simulate": server.WithScope(auth.ScopeMove, server.NewViewHandle(msv.assistantSimulatePostHandler)),
+		"/assistant/item":     server.ReadOnly(server.NewViewHandle(msv.assistantItemHandler)),
+		"/assistant/clear":    server.WithScope(auth.ScopeMove, server.NewViewHandle(msv.assistantClearAttentionPostHandler)),
 	}
 }
 
@@ -74,14 +87,45 @@ func New(server server.HTTPServer, moveServer *moveserver.MoveServer) (server.Vi
 	}, nil
 }
 
+// postForm parses the form of a POST request. It writes an error to w and
+// returns false for other methods or if the form cannot be parsed, query
+// parameters of GET requests must not change anything.
+func postForm(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
 func (msv *MoveServerView) setMovePathPostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
-	log.Printf("Received move POST request", r)
-	err := r.ParseForm()
+	log.Printf("Received set move path request: %s %s", r.Method, r.URL.Path)
+	if !postForm(w, r) {
+		return
+	}
+	err := msv.moveServer.SetMovePath(r.Form.Get("name"), r.Form.Get("move_to"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	err = msv.moveServer.SetMovePath(r.Form.Get("name"), r.Form.Get("move_to"))
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (msv *MoveServerView) setPriorityPostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	log.Printf("Received set priority request: %s %s", r.Method, r.URL.Path)
+	if !postForm(w, r) {
+		return
+	}
+	priority, err := strconv.Atoi(r.Form.Get("priority"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = msv.moveServer.SetPriority(r.Form.Get("name"), priority)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -90,13 +134,11 @@ func (msv *MoveServerView) setMovePathPostHandler(w http.ResponseWriter, r *http
 }
 
 func (msv *MoveServerView) movePostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
-	log.Printf("Received move POST request", r)
-	err := r.ParseForm()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	log.Printf("Received move request: %s %s", r.Method, r.URL.Path)
+	if !postForm(w, r) {
 		return
 	}
-	err = msv.moveServer.Move(r.Form.Get("name"))
+	err := msv.moveServer.Move(r.Form.Get("name"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -105,13 +147,19 @@ func (msv *MoveServerView) movePostHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (msv *MoveServerView) updateCacheHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
-	log.Printf("Received cache update POST request %v", r)
+	log.Printf("Received cache update request: %s %s", r.Method, r.URL.Path)
+	if !postForm(w, r) {
+		return
+	}
 	msv.moveServer.UpdateCacheAsync()
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
 func (msv *MoveServerView) updateDiskStatsHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
-	log.Printf("Received disk stats POST request %v", r)
+	log.Printf("Received disk stats request: %s %s", r.Method, r.URL.Path)
+	if !postForm(w, r) {
+		return
+	}
 	msv.moveServer.UpdateDiskStatsAsync()
 	http.Redirect(w, r, "/", http.StatusFound)
 }
@@ -138,8 +186,10 @@ func (msv *MoveServerView) moveDashboardPageHandler(w http.ResponseWriter, r *ht
 	log.Printf("messages %s", messages)
 
 	assistantEnabled := false
+	var needsAttention map[string]string
 	if msv.moveServer.Assistant != nil {
 		assistantEnabled = msv.moveServer.Assistant.IsEnabled()
+		needsAttention = msv.moveServer.Assistant.NeedsAttention()
 	}
 
 	sort.Sort(pathInfoList)
@@ -154,6 +204,7 @@ func (msv *MoveServerView) moveDashboardPageHandler(w http.ResponseWriter, r *ht
 		DiskStats        []moveserver.DiskStats
 		Messages         []*moveserver.LogMessage
 		AssistantEnabled bool
+		NeedsAttention   map[string]string
 	}{
 		PathInfo:         pathInfoList,
 		PathInfoHistory:  pathInfoHistoryList,
@@ -164,15 +215,76 @@ func (msv *MoveServerView) moveDashboardPageHandler(w http.ResponseWriter, r *ht
 		DiskStats:        msv.moveServer.GetDiskStats(),
 		Messages:         messages,
 		AssistantEnabled: assistantEnabled,
+		NeedsAttention:   needsAttention,
 	}
 	s.RenderTemplate(w, r, msv.GetName(), "torrents_page", "Torrents", context)
 }
 
-func (msv *MoveServerView) assistantHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+func (msv *MoveServerView) renderAssistantPage(w http.ResponseWriter, r *http.Request, s server.HTTPServer, simulation *moveserver.AssistantRun) {
 	context := struct {
-		Assistant *moveserver.Assistant
+		Assistant      *moveserver.Assistant
+		Simulation     *moveserver.AssistantRun
+		DecisionLog    []*moveserver.Decision
+		NeedsAttention map[string]string
 	}{
-		Assistant: msv.moveServer.Assistant,
+		Assistant:      msv.moveServer.Assistant,
+		Simulation:     simulation,
+		DecisionLog:    msv.moveServer.Assistant.DecisionLog(),
+		NeedsAttention: msv.moveServer.Assistant.NeedsAttention(),
 	}
 	s.RenderTemplate(w, r, msv.GetName(), "assistant_page", "Assistant", context)
 }
+
+func (msv *MoveServerView) assistantHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	msv.renderAssistantPage(w, r, s, nil)
+}
+
+func (msv *MoveServerView) assistantDryRunPostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if !postForm(w, r) {
+		return
+	}
+	msv.moveServer.Assistant.SetDryRun(r.Form.Get("dry_run") == "true")
+	http.Redirect(w, r, "/assistant", http.StatusFound)
+}
+
+func (msv *MoveServerView) assistantClearAttentionPostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if !postForm(w, r) {
+		return
+	}
+	msv.moveServer.Assistant.ClearAttention(r.Form.Get("name"))
+	http.Redirect(w, r, "/assistant", http.StatusFound)
+}
+
+func (msv *MoveServerView) assistantSimulatePostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/assistant", http.StatusFound)
+		return
+	}
+	msv.renderAssistantPage(w, r, s, msv.moveServer.Assistant.Simulate(r.Context()))
+}
+
+func (msv *MoveServerView) assistantItemHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	name := r.URL.Query().Get("name")
+	var since time.Time
+	if h := r.URL.Query().Get("hours"); h != "" {
+		hours, err := strconv.Atoi(h)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since = time.Now().Add(-time.Duration(hours) * time.Hour)
+	}
+
+	context := struct {
+		Name      string
+		Hours     string
+		Events    []*moveserver.ItemEvent
+		Timelines []moveserver.ItemTimelineInfo
+	}{
+		Name:      name,
+		Hours:     r.URL.Query().Get("hours"),
+		Events:    msv.moveServer.Assistant.Timeline(name, since),
+		Timelines: msv.moveServer.Assistant.Timelines(),
+	}
+	s.RenderTemplate(w, r, msv.GetName(), "assistant_item_page", "Assistant: "+name, context)
+}
@@ -0,0 +1,124 @@
+package notificationsview
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/HawkMachine/kodi_automation/platform"
+	"github.com/HawkMachine/kodi_automation/platform/notifications"
+	"github.com/HawkMachine/kodi_automation/server"
+	"github.com/HawkMachine/kodi_automation/server/auth"
+)
+
+type NotificationsView struct {
+	p *platform.Platform
+}
+
+func (nv *NotificationsView) GetName() string {
+	return "notificationsview"
+}
+
+func (nv *NotificationsView) GetTemplates() map[string][]string {
+	return map[string][]string{
+		"notifications_page": []string{
+			"base.html",
+			"notifications.html",
+		},
+	}
+}
+
+func (nv *NotificationsView) GetHandlers() map[string]server.ViewHandle {
+	return map[string]server.ViewHandle{
+		"/notifications":         server.ReadOnly(server.NewViewHandle(nv.notificationsPage)),
+		"/notifications/read":    server.NewViewHandle(nv.readPostHandler),
+		"/notifications/dismiss": server.NewViewHandle(nv.dismissPostHandler),
+		"/notifications/test":    server.NewViewHandleForRole(auth.Admin, nv.testPostHandler),
+	}
+}
+
+func (nv *NotificationsView) GetMenu() (string, map[string]string) {
+	return "Notifications", map[string]string{
+		"Notifications": "/notifications",
+	}
+}
+
+// GetMenuBadges shows the number of unread notifications in the menu.
+func (nv *NotificationsView) GetMenuBadges() map[string]int {
+	return map[string]int{
+		"Notifications": nv.p.Notifications.Unread(),
+	}
+}
+
+func New(p *platform.Platform) server.View {
+	return &NotificationsView{p: p}
+}
+
+func (nv *NotificationsView) notificationsPage(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	context := struct {
+		Notifications []notifications.Notification
+		Sinks         []string
+	}{
+		Notifications: nv.p.Notifications.List(),
+	}
+	if nv.p.Notifier != nil && auth.Allowed(r, auth.Admin) {
+		context.Sinks = nv.p.Notifier.Sinks()
+	}
+	s.RenderTemplate(w, r, nv.GetName(), "notifications_page", "Notifications", context)
+}
+
+func (nv *NotificationsView) readPostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	nv.p.Notifications.MarkAllRead()
+	http.Redirect(w, r, "/notifications", http.StatusFound)
+}
+
+func (nv *NotificationsView) dismissPostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Bad notification id: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := nv.p.Notifications.Dismiss(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, "/notifications", http.StatusFound)
+}
+
+// testPostHandler sends a test message to a sink, the result is added as a
+// notification.
+func (nv *NotificationsView) testPostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if nv.p.Notifier == nil {
+		http.Error(w, "Notifier is not running", http.StatusNotFound)
+		return
+	}
+	sink := r.Form.Get("sink")
+	if err := nv.p.Notifier.SendTest(sink); err != nil {
+		nv.p.Notifications.Add(notifications.Error, "notifier", fmt.Sprintf("Test of sink %s failed", sink), err.Error())
+	} else {
+		nv.p.Notifications.Add(notifications.Info, "notifier", fmt.Sprintf("Test of sink %s sent", sink), "")
+	}
+	http.Redirect(w, r, "/notifications", http.StatusFound)
+}
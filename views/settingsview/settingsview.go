@@ -0,0 +1,115 @@
+package settingsview
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/HawkMachine/kodi_automation/server"
+	"github.com/HawkMachine/kodi_automation/server/auth"
+)
+
+type SettingsView struct {
+	tokens *auth.Tokens
+}
+
+func (sv *SettingsView) GetName() string {
+	return "settingsview"
+}
+
+func (sv *SettingsView) GetTemplates() map[string][]string {
+	return map[string][]string{
+		"tokens_page": []string{
+			"base.html",
+			"settings_tokens.html",
+		},
+	}
+}
+
+func (sv *SettingsView) GetHandlers() map[string]server.ViewHandle {
+	return map[string]server.ViewHandle{
+		"/settings/tokens":        server.NewViewHandleForRole(auth.Admin, sv.tokensPage),
+		"/settings/tokens/create": server.NewViewHandleForRole(auth.Admin, sv.createTokenPostHandler),
+		"/settings/tokens/revoke": server.NewViewHandleForRole(auth.Admin, sv.revokeTokenPostHandler),
+	}
+}
+
+func (sv *SettingsView) GetMenu() (string, map[string]string) {
+	return "Settings", map[string]string{
+		"API Tokens": "/settings/tokens",
+	}
+}
+
+func New(tokens *auth.Tokens) server.View {
+	return &SettingsView{tokens: tokens}
+}
+
+type tokensPageContext struct {
+	Tokens []auth.Token
+	Scopes []auth.Scope
+
+	// Name and secret of a token just created, the secret is only shown once.
+	NewTokenName   string
+	NewTokenSecret string
+}
+
+func (sv *SettingsView) renderTokensPage(w http.ResponseWriter, r *http.Request, s server.HTTPServer, context tokensPageContext) {
+	context.Tokens = sv.tokens.List()
+	context.Scopes = auth.Scopes
+	s.RenderTemplate(w, r, sv.GetName(), "tokens_page", "API Tokens", context)
+}
+
+func (sv *SettingsView) tokensPage(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	sv.renderTokensPage(w, r, s, tokensPageContext{})
+}
+
+// createTokenPostHandler creates a token and shows its secret. It renders the
+// page instead of redirecting, the secret must not end up in a URL.
+func (sv *SettingsView) createTokenPostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var scopes []auth.Scope
+	for _, v := range r.Form["scope"] {
+		scope, err := auth.ParseScope(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	var createdBy string
+	if u := auth.UserFromRequest(r); u != nil {
+		createdBy = u.Username
+	}
+	name := r.Form.Get("name")
+	secret, err := sv.tokens.Create(name, scopes, createdBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	sv.renderTokensPage(w, r, s, tokensPageContext{NewTokenName: name, NewTokenSecret: secret})
+}
+
+func (sv *SettingsView) revokeTokenPostHandler(w http.ResponseWriter, r *http.Request, s server.HTTPServer) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := sv.tokens.Revoke(r.Form.Get("name")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, "/settings/tokens", http.StatusFound)
+}
@@ -29,7 +29,7 @@ func (tv *TransmissionView) GetTemplates() map[string][]string {
 
 func (tv *TransmissionView) GetHandlers() map[string]server.ViewHandle {
 	return map[string]server.ViewHandle{
-		"/transmission": server.NewViewHandle(tv.transmissionPage),
+		"/transmission": server.ReadOnly(server.NewViewHandle(tv.transmissionPage)),
 	}
 }
 
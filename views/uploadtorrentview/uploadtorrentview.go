@@ -7,6 +7,7 @@ import (
 	"github.com/HawkMachine/kodi_automation/moveserver"
 	"github.com/HawkMachine/kodi_automation/platform"
 	"github.com/HawkMachine/kodi_automation/server"
+	"github.com/HawkMachine/kodi_automation/server/auth"
 	"github.com/HawkMachine/transmission_go_api"
 )
 
@@ -46,7 +47,7 @@ func (utv *UploadTorrentView) GetTemplates() map[string][]string {
 
 func (utv *UploadTorrentView) GetHandlers() map[string]server.ViewHandle {
 	return map[string]server.ViewHandle{
-		"/upload_torrent": server.NewViewHandle(utv.uploadTorrentHandler),
+		"/upload_torrent": server.WithScope(auth.ScopeTransmission, server.NewViewHandle(utv.uploadTorrentHandler)),
 	}
 }
 
@@ -26,7 +26,7 @@ func (wv *WrapView) GetTemplates() map[string][]string {
 
 func (wv *WrapView) GetHandlers() map[string]server.ViewHandle {
 	return map[string]server.ViewHandle{
-		"/wrap/": server.NewViewHandle(wv.wrapHandler),
+		"/wrap/": server.ReadOnly(server.NewViewHandle(wv.wrapHandler)),
 	}
 }
 